@@ -0,0 +1,324 @@
+package autoroute
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("autoroute: api key not found")
+	ErrMissingAPIKey  = errors.New("autoroute: missing api key")
+	ErrInvalidAPIKey  = errors.New("autoroute: invalid api key")
+	ErrExpiredAPIKey  = errors.New("autoroute: api key has expired")
+	ErrRevokedAPIKey  = errors.New("autoroute: api key has been revoked")
+)
+
+// An APIKey is what a KeyStore knows about a key: its public ID, a hash of its secret
+// and who it belongs to. Keys are handed out as "<id>.<secret>", so the secret itself
+// is never stored. Functions behind APIKeyMiddleware can take the *APIKey of a request.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Owner     string    `json:"owner"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+// NewAPIKey generates a key for owner, returning the key to hand out once and the
+// record to save in a KeyStore
+func NewAPIKey(owner string, scopes ...string) (string, *APIKey, error) {
+	buf := make([]byte, 8+32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, secret := hex.EncodeToString(buf[:8]), hex.EncodeToString(buf[8:])
+	return id + "." + secret, &APIKey{ID: id, Hash: HashAPIKeySecret(secret), Owner: owner, Scopes: scopes}, nil
+}
+
+// HashAPIKeySecret hashes the secret half of a key the way APIKey.Hash holds it
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports whether secret is this key's, in constant time
+func (k *APIKey) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKeySecret(secret)), []byte(k.Hash)) == 1
+}
+
+// Name is the key's owner
+func (k *APIKey) Name() string {
+	return k.Owner
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return containsString(k.Scopes, scope)
+}
+
+// HasRole is always false, keys only carry scopes
+func (k *APIKey) HasRole(role string) bool {
+	return false
+}
+
+// A KeyStore looks up API keys by their ID, returning ErrAPIKeyNotFound for unknown ones
+type KeyStore interface {
+	Lookup(ctx context.Context, id string) (*APIKey, error)
+}
+
+// MemoryKeyStore is a KeyStore for keys added at runtime
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]APIKey)}
+}
+
+// Add saves a key, replacing any with the same ID
+func (s *MemoryKeyStore) Add(key *APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = *key
+}
+
+// Revoke marks a key as revoked
+func (s *MemoryKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+
+	key.Revoked = true
+	s.keys[id] = key
+	return nil
+}
+
+func (s *MemoryKeyStore) Lookup(ctx context.Context, id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return &key, nil
+}
+
+// how often a FileKeyStore checks whether its file changed
+const keyFileCheckInterval = time.Second
+
+// FileKeyStore is a KeyStore reading a JSON array of APIKeys from a file, which is
+// read again whenever it changes, so keys can be added or revoked without a restart
+type FileKeyStore struct {
+	path string
+
+	mu      sync.Mutex
+	keys    *MemoryKeyStore
+	modTime time.Time
+	checked time.Time
+}
+
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	err := s.reload(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileKeyStore) Lookup(ctx context.Context, id string) (*APIKey, error) {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.checked) >= keyFileCheckInterval {
+		// keep serving the keys we have if the file is briefly broken mid write
+		s.reload(now)
+	}
+	keys := s.keys
+	s.mu.Unlock()
+
+	return keys.Lookup(ctx, id)
+}
+
+// reload reads the file when it's changed since it was last read
+func (s *FileKeyStore) reload(now time.Time) error {
+	s.checked = now
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	if s.keys != nil && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var list []*APIKey
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+
+	keys := NewMemoryKeyStore()
+	for _, key := range list {
+		keys.Add(key)
+	}
+
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return nil
+}
+
+type APIKeyOption func(akm *APIKeyMiddleware)
+
+// WithAPIKeyHeader reads keys from a header, x-api-key unless another source is given
+func WithAPIKeyHeader(name string) APIKeyOption {
+	return func(akm *APIKeyMiddleware) {
+		akm.sources = append(akm.sources, apiKeySource{header: name})
+	}
+}
+
+// WithAPIKeyQuery reads keys from a URL query parameter
+func WithAPIKeyQuery(param string) APIKeyOption {
+	return func(akm *APIKeyMiddleware) {
+		akm.sources = append(akm.sources, apiKeySource{query: param})
+	}
+}
+
+// WithAPIKeyCookie reads keys from a cookie
+func WithAPIKeyCookie(name string) APIKeyOption {
+	return func(akm *APIKeyMiddleware) {
+		akm.sources = append(akm.sources, apiKeySource{cookie: name})
+	}
+}
+
+// apiKeySource is one of a header, query parameter or cookie
+type apiKeySource struct {
+	header, query, cookie string
+}
+
+func (src apiKeySource) read(r *http.Request) string {
+	switch {
+	case src.header != "":
+		return r.Header.Get(src.header)
+	case src.query != "":
+		return r.URL.Query().Get(src.query)
+	}
+
+	c, err := r.Cookie(src.cookie)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// APIKeyMiddleware authenticates requests with an API key from a KeyStore, answering
+// a 401 when it's missing, unknown, expired or revoked. Sources are tried in the
+// order given. The key becomes the request's Principal, and functions can take it
+// as an *APIKey arg.
+type APIKeyMiddleware struct {
+	store   KeyStore
+	sources []apiKeySource
+
+	now func() time.Time
+}
+
+func NewAPIKeyMiddleware(store KeyStore, opts ...APIKeyOption) *APIKeyMiddleware {
+	akm := &APIKeyMiddleware{
+		store: store,
+		now:   time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(akm)
+	}
+
+	if len(akm.sources) == 0 {
+		akm.sources = []apiKeySource{{header: "x-api-key"}}
+	}
+
+	return akm
+}
+
+func (akm *APIKeyMiddleware) Before(r *http.Request, h *Handler) error {
+	_, err := akm.BeforeRequest(r, h)
+	return err
+}
+
+func (akm *APIKeyMiddleware) BeforeRequest(r *http.Request, h *Handler) (*http.Request, error) {
+	var raw string
+	for _, src := range akm.sources {
+		if raw = src.read(r); raw != "" {
+			break
+		}
+	}
+
+	if raw == "" {
+		return nil, MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrMissingAPIKey}
+	}
+
+	key, err := akm.verify(r.Context(), raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return SetValue(r, key), nil
+}
+
+func (akm *APIKeyMiddleware) Values() []interface{} {
+	return []interface{}{(*APIKey)(nil)}
+}
+
+func (akm *APIKeyMiddleware) verify(ctx context.Context, raw string) (*APIKey, error) {
+	i := strings.Index(raw, ".")
+	if i < 0 {
+		return nil, MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrInvalidAPIKey}
+	}
+
+	key, err := akm.store.Lookup(ctx, raw[:i])
+	if err == ErrAPIKeyNotFound {
+		return nil, MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrInvalidAPIKey}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.Matches(raw[i+1:]) {
+		return nil, MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrInvalidAPIKey}
+	}
+
+	if key.Revoked {
+		return nil, MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrRevokedAPIKey}
+	}
+
+	if !key.ExpiresAt.IsZero() && !akm.now().Before(key.ExpiresAt) {
+		return nil, MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrExpiredAPIKey}
+	}
+
+	return key, nil
+}
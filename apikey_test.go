@@ -0,0 +1,165 @@
+package autoroute
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryKeyStore()
+	akm := NewAPIKeyMiddleware(store, WithAPIKeyHeader("x-api-key"), WithAPIKeyQuery("api_key"), WithAPIKeyCookie("api_key"))
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(akm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/orders", func(key *APIKey) TestOutput {
+		return TestOutput{Output: key.Owner}
+	}, WithRequiredScopes("orders:read"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, record, err := NewAPIKey("ian", "orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(record)
+
+	unscoped, record, err := NewAPIKey("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(record)
+
+	expired, record, err := NewAPIKey("eve", "orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.ExpiresAt = time.Now().Add(-time.Minute)
+	store.Add(record)
+
+	revoked, record, err := NewAPIKey("mallory", "orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(record)
+	err = store.Revoke(record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name   string
+		set    func(req *http.Request)
+		status int
+		body   string
+	}{
+		{"header", func(req *http.Request) { req.Header.Set("x-api-key", valid) }, http.StatusOK, `{"output":"ian"}`},
+		{"query", func(req *http.Request) { req.URL.RawQuery = "api_key=" + valid }, http.StatusOK, `{"output":"ian"}`},
+		{"cookie", func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "api_key", Value: valid}) }, http.StatusOK, `{"output":"ian"}`},
+		{"missing", func(req *http.Request) {}, http.StatusUnauthorized, `{"error":"autoroute: missing api key"}`},
+		{"wrong secret", func(req *http.Request) { req.Header.Set("x-api-key", valid[:17]+"00") }, http.StatusUnauthorized, `{"error":"autoroute: invalid api key"}`},
+		{"unknown id", func(req *http.Request) { req.Header.Set("x-api-key", "nope.nope") }, http.StatusUnauthorized, `{"error":"autoroute: invalid api key"}`},
+		{"expired", func(req *http.Request) { req.Header.Set("x-api-key", expired) }, http.StatusUnauthorized, `{"error":"autoroute: api key has expired"}`},
+		{"revoked", func(req *http.Request) { req.Header.Set("x-api-key", revoked) }, http.StatusUnauthorized, `{"error":"autoroute: api key has been revoked"}`},
+		{"missing scope", func(req *http.Request) { req.Header.Set("x-api-key", unscoped) }, http.StatusForbidden, `{"error":"autoroute: bob is missing the required scope \"orders:read\""}`},
+	}
+
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set(MimeTypeHeader, "application/json")
+		tc.set(req)
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+
+		diffJSON(t, tc.body, w.Body.String())
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	t.Parallel()
+
+	key, record, err := NewAPIKey("ian")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	write := func(keys []*APIKey, modTime time.Time) {
+		data, err := json.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(f.Name(), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.Chtimes(f.Name(), modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write([]*APIKey{record}, time.Now().Add(-time.Hour))
+	store, err := NewFileKeyStore(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(NewAPIKeyMiddleware(store)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/me", func(p Principal) TestOutput {
+		return TestOutput{Output: p.Name()}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set(MimeTypeHeader, "application/json")
+		req.Header.Set("x-api-key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	// revoking the key in the file takes effect once it's checked again
+	record.Revoked = true
+	write([]*APIKey{record}, time.Now())
+	store.mu.Lock()
+	store.checked = time.Time{}
+	store.mu.Unlock()
+
+	if w := send(); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the revoked key to be refused, got %d", w.Code)
+	}
+}
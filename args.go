@@ -0,0 +1,176 @@
+package autoroute
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+var (
+	ErrMultipleBodyArgs = errors.New("autoroute: a function can only have one body arg, every other arg must be injected")
+	ErrBadProvider      = errors.New("autoroute: providers must be a func(*http.Request) (T, error)")
+)
+
+// Query holds the first value of each URL query parameter
+type Query map[string]string
+
+func (q Query) Get(v string) string {
+	return q[v]
+}
+
+var queryType = reflect.TypeOf(make(Query))
+var requestType = reflect.TypeOf(&http.Request{})
+var responseWriterType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+
+// WithProvider lets functions take an arg of type T, built from each request by
+// provider, which must be a func(*http.Request) (T, error). A provider returning
+// a MiddlewareError fails the request with its status code, so providers are a
+// good fit for loading a *User or Tenant. Providers also override the built in
+// args, and given to NewRouter apply to every route.
+func WithProvider(provider interface{}) HandlerOption {
+	return func(h *Handler) {
+		fn := reflect.ValueOf(provider)
+		if fn.Kind() != reflect.Func {
+			h.setOptionErr(ErrBadProvider)
+			return
+		}
+
+		fnType := fn.Type()
+		if fnType.NumIn() != 1 || fnType.In(0) != requestType || fnType.NumOut() != 2 || fnType.Out(1) != errorType {
+			h.setOptionErr(ErrBadProvider)
+			return
+		}
+
+		if h.providers == nil {
+			h.providers = make(map[reflect.Type]reflect.Value)
+		}
+		h.providers[fnType.Out(0)] = fn
+	}
+}
+
+// where the value of a function arg comes from
+const (
+	argBody = iota
+	argContext
+	argHeader
+	argQuery
+	argRequest
+	argResponseWriter
+	argProvider
+	argHTTPHeader
+	argBoundStruct
+	argCookies
+	argValue
+	argPrincipal
+	argLastEventID
+)
+
+type argSpec struct {
+	source   int
+	t        reflect.Type
+	provider reflect.Value
+	// the declared types an argValue can come from
+	values []reflect.Type
+}
+
+// planArgs works out where each arg of a function comes from. Args can be in any
+// order, and anything that isn't injected is the single body arg decoded by the codec.
+// declared holds the types of values middleware sets on the request context.
+func planArgs(fnType reflect.Type, providers map[reflect.Type]reflect.Value, declared []reflect.Type) ([]argSpec, error) {
+	args := make([]argSpec, fnType.NumIn())
+	hasBody := false
+	for i := range args {
+		t := fnType.In(i)
+
+		if provider, ok := providers[t]; ok {
+			args[i] = argSpec{source: argProvider, provider: provider}
+			continue
+		}
+
+		if t == principalType {
+			args[i].source = argPrincipal
+			continue
+		}
+
+		if values := valueTypes(t, declared); len(values) > 0 {
+			args[i] = argSpec{source: argValue, t: t, values: values}
+			continue
+		}
+
+		switch {
+		case t == responseWriterType:
+			args[i].source = argResponseWriter
+		case t.Kind() == reflect.Interface && contextType.Implements(t):
+			args[i].source = argContext
+		case t.Kind() == reflect.Interface:
+			return nil, fmt.Errorf("autoroute: can't inject a %s, register a provider for it with WithProvider", t)
+		case t == headerType:
+			args[i].source = argHeader
+		case t == httpHeaderType:
+			args[i].source = argHTTPHeader
+		case t == cookiesType:
+			args[i].source = argCookies
+		case isBoundStruct(t):
+			args[i] = argSpec{source: argBoundStruct, t: t}
+		case t == queryType:
+			args[i].source = argQuery
+		case t == requestType:
+			args[i].source = argRequest
+		case t == lastEventIDType:
+			args[i].source = argLastEventID
+		default:
+			if hasBody {
+				return nil, ErrMultipleBodyArgs
+			}
+			hasBody = true
+			args[i].source = argBody
+		}
+	}
+
+	return args, nil
+}
+
+// argValue resolves a single injected arg for a request
+func (cra *CodecRequestArgs) argValue(spec argSpec) (reflect.Value, error) {
+	switch spec.source {
+	case argContext:
+		return reflect.ValueOf(cra.Request.Context()), nil
+	case argHeader:
+		return reflect.ValueOf(cra.Header), nil
+	case argQuery:
+		query := make(Query)
+		for k, v := range cra.Request.URL.Query() {
+			query[k] = v[0]
+		}
+		return reflect.ValueOf(query), nil
+	case argRequest:
+		return reflect.ValueOf(cra.Request), nil
+	case argResponseWriter:
+		return reflect.ValueOf(&cra.ResponseWriter).Elem(), nil
+	case argHTTPHeader:
+		return reflect.ValueOf(cra.Request.Header.Clone()), nil
+	case argCookies:
+		return reflect.ValueOf(cra.cookies()), nil
+	case argBoundStruct:
+		return bindRequestFields(spec.t, cra.Request.Header, cra.cookies())
+	case argValue:
+		return contextValue(cra.Request.Context(), spec), nil
+	case argLastEventID:
+		return reflect.ValueOf(LastEventID(cra.Request.Header.Get("Last-Event-ID"))), nil
+	case argPrincipal:
+		p := PrincipalFrom(cra.Request.Context())
+		if p == nil {
+			return reflect.Zero(principalType), nil
+		}
+		return reflect.ValueOf(p), nil
+	case argProvider:
+		out := spec.provider.Call([]reflect.Value{reflect.ValueOf(cra.Request)})
+		if !out[1].IsNil() {
+			return reflect.Value{}, &injectError{err: out[1].Interface().(error)}
+		}
+		return out[0], nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("autoroute: unknown arg source %d", spec.source)
+}
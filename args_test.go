@@ -0,0 +1,100 @@
+package autoroute
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type TestUser struct {
+	Name string
+}
+
+func currentTestUser(r *http.Request) (*TestUser, error) {
+	name := r.Header.Get("X-User")
+	if name == "" {
+		return nil, MiddlewareError{
+			StatusCode: http.StatusUnauthorized,
+			Err:        errors.New("no user"),
+		}
+	}
+
+	return &TestUser{Name: name}, nil
+}
+
+func TestInjectedArgs(t *testing.T) {
+	t.Parallel()
+
+	fn := func(w http.ResponseWriter, ti *TestInput, r *http.Request, q Query, u *TestUser, ctx context.Context) *TestOutput {
+		w.Header().Set("X-Method", r.Method)
+		return &TestOutput{Output: ti.Input + " " + q.Get("page") + " " + u.Name}
+	}
+
+	h, err := NewHandler(fn, WithCodec(JSONCodec), WithProvider(currentTestUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/?page=2", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.Header.Set("X-User", "ian")
+	h.ServeHTTP(w, req)
+
+	diffJSON(t, `{"output":"yo 2 ian"}`, w.Body.String())
+	if w.Header().Get("X-Method") != http.MethodPost {
+		t.Fatal("expected the function to write a header")
+	}
+
+	// providers fail like middleware
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set(MimeTypeHeader, "application/json")
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %d", w.Code)
+	}
+	diffJSON(t, `{"error":"no user"}`, w.Body.String())
+}
+
+func TestInjectedArgsValidation(t *testing.T) {
+	t.Parallel()
+
+	var cases = []struct {
+		name     string
+		fn       interface{}
+		opts     []HandlerOption
+		expected string
+	}{
+		{
+			"two body args",
+			func(a *TestInput, b *TestInput) {},
+			nil,
+			ErrMultipleBodyArgs.Error(),
+		},
+		{
+			"bad provider",
+			func(u *TestUser) {},
+			[]HandlerOption{WithProvider(func() *TestUser { return nil })},
+			ErrBadProvider.Error(),
+		},
+		{
+			"unknown interface",
+			func(r io.Reader) {},
+			nil,
+			"autoroute: can't inject a io.Reader, register a provider for it with WithProvider",
+		},
+	}
+
+	for _, c := range cases {
+		_, err := NewHandler(c.fn, append(c.opts, WithCodec(JSONCodec))...)
+		if err == nil || err.Error() != c.expected {
+			t.Fatalf("%s: expected %q, got %v", c.name, c.expected, err)
+		}
+	}
+}
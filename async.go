@@ -0,0 +1,411 @@
+package autoroute
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobNotFound  = errors.New("autoroute: job not found")
+	ErrJobQueueFull = errors.New("autoroute: too many pending jobs")
+	ErrAsyncClosed  = errors.New("autoroute: async handler is closed")
+)
+
+// asyncQueueSize is how many jobs of a single handler can wait for a worker
+const asyncQueueSize = 128
+
+// JobsPath is where a Router serves the status of jobs created by async handlers
+const JobsPath = "/jobs/"
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// A Job is a single call of an async handler
+type Job struct {
+	ID     string    `json:"id"`
+	Status JobStatus `json:"status"`
+	// Handler is the Name of the handler that created the job
+	Handler string `json:"handler"`
+	// Route is the method and path the handler was registered at, such as "POST /export",
+	// which decides whose middleware guards the job. It's empty for handlers that were
+	// never registered on a Router.
+	Route string `json:"route"`
+
+	// Result is the JSON encoded output of a succeeded job
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error is the error returned by a failed job
+	Error string `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// A JobStore persists jobs as they move from pending through running to succeeded or failed
+type JobStore interface {
+	// Save creates or replaces a job
+	Save(ctx context.Context, job *Job) error
+	// Get returns ErrJobNotFound for unknown ids
+	Get(ctx context.Context, id string) (*Job, error)
+}
+
+// how often a MemoryJobStore looks for expired jobs to drop
+const jobSweepInterval = time.Minute
+
+type MemoryJobStoreOption func(mjs *MemoryJobStore)
+
+// WithFinishedJobTTL sets how long a MemoryJobStore keeps jobs once they've succeeded
+// or failed, an hour by default
+func WithFinishedJobTTL(ttl time.Duration) MemoryJobStoreOption {
+	return func(mjs *MemoryJobStore) {
+		mjs.ttl = ttl
+	}
+}
+
+// MemoryJobStore is a JobStore which keeps jobs in memory, and so loses them on restart.
+// Finished jobs are dropped once their TTL has passed.
+type MemoryJobStore struct {
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	jobs      map[string]Job
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+func NewMemoryJobStore(opts ...MemoryJobStoreOption) *MemoryJobStore {
+	mjs := &MemoryJobStore{
+		ttl:  time.Hour,
+		jobs: make(map[string]Job),
+		now:  time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(mjs)
+	}
+
+	return mjs
+}
+
+func (mjs *MemoryJobStore) Save(ctx context.Context, job *Job) error {
+	mjs.mu.Lock()
+	defer mjs.mu.Unlock()
+
+	mjs.jobs[job.ID] = *job
+
+	now := mjs.now()
+	if now.Sub(mjs.lastSweep) >= jobSweepInterval {
+		mjs.lastSweep = now
+		for id, job := range mjs.jobs {
+			if mjs.expired(&job, now) {
+				delete(mjs.jobs, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (mjs *MemoryJobStore) Get(ctx context.Context, id string) (*Job, error) {
+	mjs.mu.RLock()
+	defer mjs.mu.RUnlock()
+
+	job, ok := mjs.jobs[id]
+	if !ok || mjs.expired(&job, mjs.now()) {
+		return nil, ErrJobNotFound
+	}
+
+	return &job, nil
+}
+
+// expired reports whether job finished longer than the TTL ago
+func (mjs *MemoryJobStore) expired(job *Job, now time.Time) bool {
+	finished := job.Status == JobSucceeded || job.Status == JobFailed
+	return finished && now.Sub(job.UpdatedAt) >= mjs.ttl
+}
+
+// WithAsync runs the function in a background worker pool instead of during the request.
+// The request is decoded and passes middleware as usual, then gets an immediate
+// 202 Accepted with the pending Job as its body and a Location of JobsPath followed by
+// the job's id, which a Router serves. Once finished, the job holds the function's
+// output encoded as JSON, or its error.
+// Functions are called with a context that keeps the request's values but isn't canceled
+// with it. Codecs which don't decode a single body value, such as NDJSONCodec and SSECodec,
+// still run synchronously.
+// Only a Router serves jobs, and only those of handlers registered on it: a Handler used
+// on its own leaves its jobs in store, for the caller to serve. Workers run until the
+// Handler, or its Router, is closed.
+func WithAsync(store JobStore) HandlerOption {
+	return func(h *Handler) {
+		h.async = &asyncRunner{
+			store:   store,
+			workers: 4,
+		}
+	}
+}
+
+// WithAsyncWorkers sets how many jobs of an async handler run at once, 4 by default.
+// It must come after WithAsync.
+func WithAsyncWorkers(n int) HandlerOption {
+	return func(h *Handler) {
+		if h.async != nil && n > 0 {
+			h.async.workers = n
+		}
+	}
+}
+
+type asyncCall struct {
+	job      *Job
+	callArgs []reflect.Value
+}
+
+// an asyncRunner is the worker pool of a single async handler, started on its first job
+type asyncRunner struct {
+	store   JobStore
+	workers int
+	// set by Router.Register
+	route string
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan asyncCall
+	wg     sync.WaitGroup
+}
+
+// enqueue hands call to the workers, starting them the first time
+func (ar *asyncRunner) enqueue(h *Handler, call asyncCall) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.closed {
+		return ErrAsyncClosed
+	}
+
+	if ar.queue == nil {
+		ar.queue = make(chan asyncCall, asyncQueueSize)
+		ar.wg.Add(ar.workers)
+		for i := 0; i < ar.workers; i++ {
+			go func() {
+				defer ar.wg.Done()
+				for call := range ar.queue {
+					ar.run(h, call)
+				}
+			}()
+		}
+	}
+
+	select {
+	case ar.queue <- call:
+		return nil
+	default:
+		return ErrJobQueueFull
+	}
+}
+
+// close refuses new jobs and waits on the workers to finish those already queued
+func (ar *asyncRunner) close() {
+	ar.mu.Lock()
+	if !ar.closed {
+		ar.closed = true
+		if ar.queue != nil {
+			close(ar.queue)
+		}
+	}
+	ar.mu.Unlock()
+
+	ar.wg.Wait()
+}
+
+// submit decodes the request into call args and queues them as a new job
+func (ar *asyncRunner) submit(h *Handler, vc valueCodec, w http.ResponseWriter, r *http.Request) {
+	// the function runs after the response is sent, so anything it writes is discarded
+	cra := h.codecRequestArgs(newResponseRecorder(), r.WithContext(detachedContext{r.Context()}))
+	callArgs, err := buildCallArgs(cra, vc.decode)
+	if err != nil {
+		writeCallArgsError(w, cra.ErrorHandler, err)
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.errorHandler(w, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        id,
+		Status:    JobPending,
+		Handler:   h.Name(),
+		Route:     ar.route,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = ar.store.Save(r.Context(), job)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.errorHandler(w, err)
+		return
+	}
+
+	// workers own job once it's queued
+	accepted := *job
+
+	err = ar.enqueue(h, asyncCall{job: job, callArgs: callArgs})
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		ar.store.Save(r.Context(), job)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		h.errorHandler(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", JobsPath+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&accepted)
+}
+
+func (ar *asyncRunner) run(h *Handler, call asyncCall) {
+	ctx := context.Background()
+	job := call.job
+
+	ar.update(ctx, job, JobRunning)
+
+	result, err := ar.call(h, call.callArgs)
+	if err != nil {
+		job.Error = err.Error()
+		ar.update(ctx, job, JobFailed)
+		return
+	}
+
+	job.Result, err = json.Marshal(result)
+	if err != nil {
+		job.Error = err.Error()
+		ar.update(ctx, job, JobFailed)
+		return
+	}
+
+	ar.update(ctx, job, JobSucceeded)
+}
+
+func (ar *asyncRunner) call(h *Handler, callArgs []reflect.Value) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			result = nil
+			err = fmt.Errorf("autoroute: %v", p)
+		}
+	}()
+
+	return outputResult(h.reflectFn.Call(callArgs))
+}
+
+func (ar *asyncRunner) update(ctx context.Context, job *Job, status JobStatus) {
+	job.Status = status
+	job.UpdatedAt = time.Now().UTC()
+
+	// there's nobody left to tell about a failed save, and the job keeps its previous state
+	ar.store.Save(ctx, job)
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// a detachedContext keeps the values of a request's context but is never canceled,
+// so jobs outlive their request
+type detachedContext struct {
+	parent context.Context
+}
+
+func (dc detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (dc detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (dc detachedContext) Err() error {
+	return nil
+}
+
+func (dc detachedContext) Value(key interface{}) interface{} {
+	return dc.parent.Value(key)
+}
+
+// jobsHandler serves GET JobsPath{id} for every JobStore used by the router's handlers.
+// Jobs are only shown to requests that pass the middleware of the route which created them.
+type jobsHandler struct {
+	router *Router
+}
+
+func (jh *jobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, JobsPath)
+	for _, store := range jh.router.jobStores {
+		job, err := store.Get(r.Context(), id)
+		if err == ErrJobNotFound {
+			continue
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			jh.router.defaultErrorHandler(w, err)
+			return
+		}
+
+		// a job whose route is gone can't be checked against its middleware, so it's hidden
+		h, ok := jh.router.handlerByRoute(job.Route)
+		if !ok {
+			break
+		}
+
+		var jobReq *http.Request
+		jobReq, err = h.before(r, h.middlewares)
+		if err == nil {
+			err = h.authorize(jobReq)
+		}
+		if err != nil {
+			h.writeMiddlewareError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	jh.router.defaultErrorHandler(w, ErrJobNotFound)
+}
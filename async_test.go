@@ -0,0 +1,256 @@
+package autoroute
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForJob polls a job until it's finished
+func waitForJob(t *testing.T, router *Router, location string, user string) *Job {
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, location, nil)
+		if user != "" {
+			req.SetBasicAuth(user, user)
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected a 200 for the job, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var job Job
+		err := json.Unmarshal(w.Body.Bytes(), &job)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status == JobSucceeded || job.Status == JobFailed {
+			return &job
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("job never finished")
+	return nil
+}
+
+func TestAsync(t *testing.T) {
+	t.Parallel()
+	store := NewMemoryJobStore()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	err = router.Register(http.MethodPost, "/slow", func(ti *TestInput) *TestOutput {
+		<-release
+		return &TestOutput{Output: ti.Input}
+	}, WithAsync(store), WithAsyncWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/slow", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set(MimeTypeHeader, "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected a 202, got %d", w.Code)
+	}
+
+	var accepted Job
+	err = json.Unmarshal(w.Body.Bytes(), &accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if accepted.Status != JobPending || w.Header().Get("Location") != JobsPath+accepted.ID {
+		t.Fatalf("unexpected accepted job %+v at %s", accepted, w.Header().Get("Location"))
+	}
+
+	close(release)
+	// the worker can only be waited on once it's released
+	defer router.Close()
+
+	job := waitForJob(t, router, w.Header().Get("Location"), "")
+	if job.Status != JobSucceeded {
+		t.Fatalf("expected the job to succeed, got %+v", job)
+	}
+
+	diffJSON(t, `{"output":"yo"}`, string(job.Result))
+
+	if job.Route != "POST /slow" {
+		t.Fatalf("expected the job's route, got %q", job.Route)
+	}
+
+	// decoding still happens during the request
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/slow", strings.NewReader(""))
+	req.Header.Set(MimeTypeHeader, "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a 400, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, JobsPath+"missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected a 404, got %d", w.Code)
+	}
+
+	// a job from a route the router doesn't have can't pass its middleware
+	err = store.Save(context.Background(), &Job{ID: "orphan", Status: JobSucceeded, Route: "POST /gone", UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, JobsPath+"orphan", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected a 404, got %d", w.Code)
+	}
+}
+
+func TestMemoryJobStoreTTL(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryJobStore(WithFinishedJobTTL(time.Hour))
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for _, job := range []*Job{
+		{ID: "running", Status: JobRunning, UpdatedAt: now},
+		{ID: "done", Status: JobSucceeded, UpdatedAt: now},
+	} {
+		err := store.Save(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(59 * time.Minute)
+	if _, err := store.Get(ctx, "done"); err != nil {
+		t.Fatalf("expected the job within its ttl, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := store.Get(ctx, "done"); err != ErrJobNotFound {
+		t.Fatalf("expected the job to expire, got %v", err)
+	}
+
+	// saving sweeps expired jobs, leaving unfinished ones however old
+	err := store.Save(ctx, &Job{ID: "new", Status: JobPending, UpdatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.jobs) != 2 {
+		t.Fatalf("expected the finished job to be dropped, got %v", store.jobs)
+	}
+
+	if _, err := store.Get(ctx, "running"); err != nil {
+		t.Fatalf("expected the running job to stay, got %v", err)
+	}
+}
+
+func TestAsyncFailure(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	err = router.Register(http.MethodPost, "/error", ts.DoThingErrorReturn,
+		WithAsync(NewMemoryJobStore()),
+		WithMiddleware(NewBasicAuthMiddleware("user", "user")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/error", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.SetBasicAuth("user", "user")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected a 202, got %d", w.Code)
+	}
+
+	location := w.Header().Get("Location")
+	job := waitForJob(t, router, location, "user")
+	if job.Status != JobFailed || job.Error != "sup" {
+		t.Fatalf("expected the job to fail, got %+v", job)
+	}
+
+	// jobs are protected by the middleware of their handler
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, location, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %d", w.Code)
+	}
+}
+
+func TestAsyncClose(t *testing.T) {
+	t.Parallel()
+	store := NewMemoryJobStore()
+
+	handler, err := NewHandler(func(ti *TestInput) *TestOutput {
+		return &TestOutput{Output: ti.Input}
+	}, WithCodec(JSONCodec), WithAsync(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submit := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"input": "yo"}`))
+		req.Header.Set(MimeTypeHeader, "application/json")
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := submit()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected a 202, got %d", w.Code)
+	}
+
+	var accepted Job
+	err = json.Unmarshal(w.Body.Bytes(), &accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queued jobs still run, and an unregistered handler's jobs have no route
+	handler.Close()
+	job, err := store.Get(context.Background(), accepted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Status != JobSucceeded || job.Route != "" {
+		t.Fatalf("expected the queued job to succeed without a route, got %+v", job)
+	}
+
+	w = submit()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 once closed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package autoroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrUnauthenticated = errors.New("autoroute: authentication required")
+)
+
+// A Principal is whoever authenticating middleware let through. Middleware supplies it
+// by setting a value implementing Principal with SetValue, which is then checked
+// against WithRequiredScopes and WithRoles, and can be taken by functions as a
+// Principal arg.
+type Principal interface {
+	// Name identifies the principal, such as a user id or an api key's owner
+	Name() string
+	HasScope(scope string) bool
+	HasRole(role string) bool
+}
+
+var principalType = reflect.TypeOf((*Principal)(nil)).Elem()
+
+type principalKey struct{}
+
+// PrincipalFrom returns the Principal last set on a request context, or nil
+func PrincipalFrom(ctx context.Context) Principal {
+	p, _ := ctx.Value(principalKey{}).(Principal)
+	return p
+}
+
+// WithRequiredScopes only lets principals with every one of scopes call the function,
+// answering a 403 otherwise, or a 401 when no middleware authenticated the request
+func WithRequiredScopes(scopes ...string) HandlerOption {
+	return func(h *Handler) {
+		h.requiredScopes = append(h.requiredScopes, scopes...)
+	}
+}
+
+// WithRoles only lets principals with at least one of roles call the function,
+// answering a 403 otherwise, or a 401 when no middleware authenticated the request
+func WithRoles(roles ...string) HandlerOption {
+	return func(h *Handler) {
+		h.roles = append(h.roles, roles...)
+	}
+}
+
+// RequiredScopes are the scopes given with WithRequiredScopes, for documenting the
+// security requirements of a route
+func (h *Handler) RequiredScopes() []string {
+	return h.requiredScopes
+}
+
+// Roles are the roles given with WithRoles, any one of which is required
+func (h *Handler) Roles() []string {
+	return h.roles
+}
+
+// authorize checks the principal of a request, after middleware has run, against the
+// scopes and roles the function requires
+func (h *Handler) authorize(r *http.Request) error {
+	if len(h.requiredScopes) == 0 && len(h.roles) == 0 {
+		return nil
+	}
+
+	p := PrincipalFrom(r.Context())
+	if p == nil {
+		return MiddlewareError{StatusCode: http.StatusUnauthorized, Err: ErrUnauthenticated}
+	}
+
+	for _, scope := range h.requiredScopes {
+		if !p.HasScope(scope) {
+			return MiddlewareError{
+				StatusCode: http.StatusForbidden,
+				Err:        fmt.Errorf("autoroute: %s is missing the required scope %q", p.Name(), scope),
+			}
+		}
+	}
+
+	if len(h.roles) == 0 {
+		return nil
+	}
+
+	for _, role := range h.roles {
+		if p.HasRole(role) {
+			return nil
+		}
+	}
+
+	return MiddlewareError{
+		StatusCode: http.StatusForbidden,
+		Err:        fmt.Errorf("autoroute: %s needs one of the roles %s", p.Name(), strings.Join(h.roles, ", ")),
+	}
+}
+
+// Name is the sub claim
+func (c *Claims) Name() string {
+	return c.Subject
+}
+
+// HasScope looks for scope in the space separated scope claim, or the scp claim
+func (c *Claims) HasScope(scope string) bool {
+	if s, ok := c.Raw["scope"].(string); ok && containsString(strings.Fields(s), scope) {
+		return true
+	}
+
+	return claimContains(c.Raw["scp"], scope)
+}
+
+// HasRole looks for role in the roles claim
+func (c *Claims) HasRole(role string) bool {
+	return claimContains(c.Raw["roles"], role)
+}
+
+// claimContains reports whether a claim that's a string or a list of them has s
+func claimContains(claim interface{}, s string) bool {
+	switch v := claim.(type) {
+	case string:
+		return containsString(strings.Fields(v), s)
+	case []interface{}:
+		for _, item := range v {
+			if item == s {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,85 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthorization(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("test-secret")
+	jm, err := NewJWTMiddleware(WithJWTKey("", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/orders", func(p Principal) TestOutput {
+		return TestOutput{Output: "created by " + p.Name()}
+	}, WithName("createOrder"), WithMiddleware(jm), WithRequiredScopes("orders:read", "orders:write"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/admin", func() TestOutput {
+		return TestOutput{Output: "ok"}
+	}, WithMiddleware(jm), WithRoles("admin", "support"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing authenticates requests to this one
+	err = router.Register(http.MethodGet, "/open", func() TestOutput {
+		return TestOutput{Output: "ok"}
+	}, WithRoles("admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := func(claims map[string]interface{}) string {
+		claims["sub"] = "ian"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		return signJWT(t, claims, "", secret)
+	}
+
+	tcs := []struct {
+		method, path, token string
+		status              int
+		body                string
+	}{
+		{http.MethodPost, "/orders", token(map[string]interface{}{"scope": "orders:read orders:write"}), http.StatusOK, `{"output":"created by ian"}`},
+		{http.MethodPost, "/orders", token(map[string]interface{}{"scp": []string{"orders:write", "orders:read"}}), http.StatusOK, `{"output":"created by ian"}`},
+		{http.MethodPost, "/orders", token(map[string]interface{}{"scope": "orders:read"}), http.StatusForbidden, `{"error":"autoroute: ian is missing the required scope \"orders:write\""}`},
+		{http.MethodGet, "/admin", token(map[string]interface{}{"roles": []string{"support"}}), http.StatusOK, `{"output":"ok"}`},
+		{http.MethodGet, "/admin", token(map[string]interface{}{"roles": "viewer"}), http.StatusForbidden, `{"error":"autoroute: ian needs one of the roles admin, support"}`},
+		{http.MethodGet, "/open", "", http.StatusUnauthorized, `{"error":"autoroute: authentication required"}`},
+	}
+
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set(MimeTypeHeader, "application/json")
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d %s", tc.method, tc.path, tc.status, w.Code, w.Body.String())
+		}
+
+		diffJSON(t, tc.body, w.Body.String())
+	}
+
+	h, _ := router.handlerByName("createOrder")
+	if h == nil || len(h.RequiredScopes()) != 2 {
+		t.Fatal("expected the required scopes to be documented on the handler")
+	}
+}
@@ -0,0 +1,174 @@
+package autoroute
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/autonaut/autoroute/internal/htpasswd"
+)
+
+// maxBasicAuthPasswordBytes bounds the passwords checked, as SHA-crypt's cost grows
+// with the square of their length
+const maxBasicAuthPasswordBytes = 256
+
+var (
+	ErrMissingBasicAuth = errors.New("basic auth required")
+	ErrInvalidBasicAuth = errors.New("invalid basic auth")
+)
+
+// BasicAuthUser is who BasicAuthMiddleware let through, functions behind it can take
+// it as a *BasicAuthUser arg
+type BasicAuthUser struct {
+	Username string
+}
+
+// Name is the username
+func (u *BasicAuthUser) Name() string {
+	return u.Username
+}
+
+// HasScope is always false, basic auth users have no scopes
+func (u *BasicAuthUser) HasScope(scope string) bool {
+	return false
+}
+
+// HasRole is always false, basic auth users have no roles
+func (u *BasicAuthUser) HasRole(role string) bool {
+	return false
+}
+
+type BasicAuthOption func(bam *BasicAuthMiddleware)
+
+// WithBasicAuthRealm sets the realm of the challenge, "autoroute" by default
+func WithBasicAuthRealm(realm string) BasicAuthOption {
+	return func(bam *BasicAuthMiddleware) {
+		bam.realm = realm
+	}
+}
+
+// BasicAuthMiddleware authenticates requests with http basic auth, answering a 401
+// with a WWW-Authenticate challenge when credentials are missing or wrong. Passwords
+// are compared in constant time. The user becomes the request's Principal, and
+// functions can take it as a *BasicAuthUser arg.
+type BasicAuthMiddleware struct {
+	realm string
+
+	// username, password are the only user of NewBasicAuthMiddleware
+	username, password [sha256.Size]byte
+
+	// users maps usernames to htpasswd hashes, dummy is checked for unknown users so
+	// they take as long to refuse as wrong passwords
+	users map[string]string
+	dummy string
+}
+
+// NewBasicAuthMiddleware lets in a single user
+func NewBasicAuthMiddleware(user, pwd string, opts ...BasicAuthOption) *BasicAuthMiddleware {
+	bam := &BasicAuthMiddleware{
+		realm: "autoroute",
+		// digests are compared rather than the values so comparing doesn't leak lengths
+		username: sha256.Sum256([]byte(user)),
+		password: sha256.Sum256([]byte(pwd)),
+	}
+
+	for _, opt := range opts {
+		opt(bam)
+	}
+
+	return bam
+}
+
+// NewBasicAuthMiddlewareFromFile lets in the users of an htpasswd file, whose passwords
+// must be hashed with bcrypt (htpasswd -B) or SHA-crypt ($5$ or $6$)
+func NewBasicAuthMiddlewareFromFile(path string, opts ...BasicAuthOption) (*BasicAuthMiddleware, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users, err := htpasswd.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, fmt.Errorf("autoroute: no users in %s", path)
+	}
+
+	bam := &BasicAuthMiddleware{
+		realm: "autoroute",
+		users: users,
+	}
+
+	for _, hash := range users {
+		bam.dummy = hash
+		break
+	}
+
+	for _, opt := range opts {
+		opt(bam)
+	}
+
+	return bam, nil
+}
+
+func (bam *BasicAuthMiddleware) Before(r *http.Request, h *Handler) error {
+	_, err := bam.BeforeRequest(r, h)
+	return err
+}
+
+func (bam *BasicAuthMiddleware) BeforeRequest(r *http.Request, h *Handler) (*http.Request, error) {
+	uname, pwd, ok := r.BasicAuth()
+	if !ok {
+		return nil, bam.unauthorized(ErrMissingBasicAuth)
+	}
+
+	if !bam.check(uname, pwd) {
+		return nil, bam.unauthorized(ErrInvalidBasicAuth)
+	}
+
+	return SetValue(r, &BasicAuthUser{Username: uname}), nil
+}
+
+func (bam *BasicAuthMiddleware) Values() []interface{} {
+	return []interface{}{(*BasicAuthUser)(nil)}
+}
+
+func (bam *BasicAuthMiddleware) check(uname, pwd string) bool {
+	// refused before looking the user up, so known and unknown users take as long
+	if len(pwd) > maxBasicAuthPasswordBytes {
+		return false
+	}
+
+	if bam.users == nil {
+		u := sha256.Sum256([]byte(uname))
+		p := sha256.Sum256([]byte(pwd))
+		// both are always compared, so a right username isn't any slower to refuse
+		return subtle.ConstantTimeCompare(u[:], bam.username[:])&subtle.ConstantTimeCompare(p[:], bam.password[:]) == 1
+	}
+
+	hash, known := bam.users[uname]
+	if !known {
+		hash = bam.dummy
+	}
+
+	ok, err := htpasswd.Verify(hash, pwd)
+	return err == nil && ok && known
+}
+
+// unauthorized builds a 401 with a challenge for the realm
+func (bam *BasicAuthMiddleware) unauthorized(err error) MiddlewareError {
+	realm := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(bam.realm)
+
+	return MiddlewareError{
+		StatusCode: http.StatusUnauthorized,
+		Err:        err,
+		Header:     http.Header{"Www-Authenticate": {`Basic realm="` + realm + `", charset="UTF-8"`}},
+	}
+}
@@ -0,0 +1,132 @@
+package autoroute
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuthMiddlewareFromFile(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`# bcrypt and SHA-crypt
+ian:$2b$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm
+bob:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bam, err := NewBasicAuthMiddlewareFromFile(f.Name(), WithBasicAuthRealm("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(bam))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/whoami", func(user *BasicAuthUser) TestOutput {
+		return TestOutput{Output: user.Username}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name      string
+		user, pwd string
+		status    int
+		body      string
+	}{
+		{"bcrypt", "ian", "password", http.StatusOK, `{"output":"ian"}`},
+		{"sha-crypt", "bob", "Hello world!", http.StatusOK, `{"output":"bob"}`},
+		{"wrong password", "ian", "Hello world!", http.StatusUnauthorized, `{"error":"invalid basic auth"}`},
+		{"unknown user", "eve", "password", http.StatusUnauthorized, `{"error":"invalid basic auth"}`},
+		{"too long", "bob", strings.Repeat("a", 257), http.StatusUnauthorized, `{"error":"invalid basic auth"}`},
+		{"too long unknown user", "eve", strings.Repeat("a", 257), http.StatusUnauthorized, `{"error":"invalid basic auth"}`},
+		{"missing", "", "", http.StatusUnauthorized, `{"error":"basic auth required"}`},
+	}
+
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		req.Header.Set(MimeTypeHeader, "application/json")
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.pwd)
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+
+		diffJSON(t, tc.body, w.Body.String())
+
+		challenge := w.Header().Get("WWW-Authenticate")
+		if tc.status == http.StatusUnauthorized && challenge != `Basic realm="orders", charset="UTF-8"` {
+			t.Fatalf("%s: unexpected challenge %q", tc.name, challenge)
+		}
+	}
+}
+
+func TestBasicAuthMiddlewareFromFileInvalid(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	// plain MD5 and SHA1 htpasswd hashes are too weak to accept
+	_, err = f.WriteString("ian:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewBasicAuthMiddlewareFromFile(f.Name())
+	if err == nil {
+		t.Fatal("expected an error for an unsupported hash")
+	}
+}
+
+func TestBasicAuthMiddlewarePasswordTooLong(t *testing.T) {
+	t.Parallel()
+
+	pwd := strings.Repeat("a", 257)
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(NewBasicAuthMiddleware("user", pwd)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/whoami", func(user *BasicAuthUser) TestOutput {
+		return TestOutput{Output: user.Username}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// even the right password is refused once it's over the limit
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.SetBasicAuth("user", pwd)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d %s", w.Code, w.Body.String())
+	}
+}
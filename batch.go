@@ -0,0 +1,259 @@
+package autoroute
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+)
+
+// maxBatchBytes limits the size of a whole batch request (1 MiB)
+const maxBatchBytes = 1 << 20
+
+type BatchOption func(bh *batchHandler)
+
+// WithBatchConcurrency runs up to n sub-requests of a batch at once. The default of
+// one runs them sequentially, in order.
+func WithBatchConcurrency(n int) BatchOption {
+	return func(bh *batchHandler) {
+		if n < 1 {
+			n = 1
+		}
+		bh.concurrency = n
+	}
+}
+
+// WithBatchMaxRequests limits how many sub-requests a single batch can contain,
+// 20 by default
+func WithBatchMaxRequests(n int) BatchOption {
+	return func(bh *batchHandler) {
+		bh.maxRequests = n
+	}
+}
+
+// A BatchRequest is a single sub-request of a batch
+type BatchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is sent as is, so it's usually a JSON object
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// A BatchResponse is the result of a single sub-request of a batch
+type BatchResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	// Body is embedded as is when it's JSON, and as a string otherwise
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// EnableBatch accepts POSTs of a JSON array of BatchRequests at path, dispatches each
+// through the router as if it were its own request, and responds with a JSON array of
+// BatchResponses in the same order. Sub-requests inherit the headers of the batch
+// request, so they're authenticated the same way, and their own headers win. Bodies
+// are JSON unless their headers say otherwise, and a sub-request that panics gets a 500.
+func (ro *Router) EnableBatch(path string, opts ...BatchOption) error {
+	bh := &batchHandler{
+		router:      ro,
+		path:        path,
+		concurrency: 1,
+		maxRequests: 20,
+	}
+
+	for _, opt := range opts {
+		opt(bh)
+	}
+
+	return ro.Mount(path, bh)
+}
+
+type batchHandler struct {
+	router *Router
+	path   string
+
+	concurrency int
+	maxRequests int
+}
+
+func (bh *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch []BatchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&batch)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		bh.router.defaultErrorHandler(w, err)
+		return
+	}
+
+	if len(batch) > bh.maxRequests {
+		w.WriteHeader(http.StatusBadRequest)
+		bh.router.defaultErrorHandler(w, fmt.Errorf("autoroute: a batch can contain at most %d requests", bh.maxRequests))
+		return
+	}
+
+	responses := make([]BatchResponse, len(batch))
+	if bh.concurrency == 1 {
+		for i := range batch {
+			responses[i] = bh.run(r, &batch[i])
+		}
+	} else {
+		sem := make(chan struct{}, bh.concurrency)
+		var wg sync.WaitGroup
+		for i := range batch {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				responses[i] = bh.run(r, &batch[i])
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
+
+// run runs a single sub-request, turning a panic into a 500 for it alone. Outside of
+// net/http's own goroutine nothing else would recover it.
+func (bh *batchHandler) run(r *http.Request, br *BatchRequest) (resp BatchResponse) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			resp = batchErrorResponse(http.StatusInternalServerError, fmt.Errorf("autoroute: sub-request panicked: %v", rec))
+		}
+	}()
+
+	return bh.do(r, br)
+}
+
+// do runs a single sub-request through the router
+func (bh *batchHandler) do(r *http.Request, br *BatchRequest) BatchResponse {
+	body := []byte(br.Body)
+	if contentType, ok := br.Headers[MimeTypeHeader]; ok && len(body) > 0 && body[0] == '"' {
+		// a JSON string body for anything but JSON is sent unquoted, so text bodies are easy to send
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		var text string
+		if mediaType != "application/json" && json.Unmarshal(body, &text) == nil {
+			body = []byte(text)
+		}
+	}
+
+	sub, err := http.NewRequest(br.Method, br.Path, bytes.NewReader(body))
+	if err != nil {
+		return batchErrorResponse(http.StatusBadRequest, err)
+	}
+
+	// ask the router, so a query string or another batch endpoint doesn't get around this
+	if handler, _ := bh.router.match(sub); isBatchHandler(handler) {
+		return batchErrorResponse(http.StatusBadRequest, errors.New("autoroute: batches can't be nested"))
+	}
+
+	sub = sub.WithContext(r.Context())
+	sub.Host = r.Host
+	sub.RemoteAddr = r.RemoteAddr
+	sub.RequestURI = br.Path
+	for k, v := range r.Header {
+		if k == "Content-Length" || k == MimeTypeHeader {
+			continue
+		}
+		sub.Header[k] = append([]string(nil), v...)
+	}
+
+	// bodies are JSON unless a header says otherwise, and so are the empty ones,
+	// as the JSON codec decodes those as an empty object
+	sub.Header.Set(MimeTypeHeader, "application/json")
+
+	for k, v := range br.Headers {
+		sub.Header.Set(k, v)
+	}
+
+	rec := newResponseRecorder()
+	bh.router.ServeHTTP(rec, sub)
+
+	resp := BatchResponse{
+		Status:  rec.status,
+		Headers: rec.header,
+	}
+
+	if resp.Status == 0 {
+		// nothing was written at all
+		resp.Status = http.StatusOK
+	}
+
+	if rec.body.Len() > 0 {
+		resp.Body = rec.bodyJSON()
+	}
+
+	return resp
+}
+
+func isBatchHandler(h http.Handler) bool {
+	_, ok := h.(*batchHandler)
+	return ok
+}
+
+func batchErrorResponse(status int, err error) BatchResponse {
+	body, _ := json.Marshal(map[string]interface{}{"error": err.Error()})
+	return BatchResponse{
+		Status: status,
+		Body:   body,
+	}
+}
+
+// a responseRecorder is an in memory http.ResponseWriter
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: make(http.Header),
+	}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.WriteHeader(http.StatusOK)
+	return rr.body.Write(b)
+}
+
+// Flush is a no-op, so streaming handlers can run against a recorder
+func (rr *responseRecorder) Flush() {}
+
+// bodyJSON returns the recorded body as JSON, quoting it unless it already is JSON
+func (rr *responseRecorder) bodyJSON() json.RawMessage {
+	body := bytes.TrimSpace(rr.body.Bytes())
+	mediaType, _, _ := mime.ParseMediaType(rr.header.Get(MimeTypeHeader))
+	if mediaType == "application/json" && json.Valid(body) {
+		return body
+	}
+
+	quoted, _ := json.Marshal(rr.body.String())
+	return quoted
+}
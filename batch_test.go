@@ -0,0 +1,141 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithCodec(TextCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/thing", ts.DoThing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a separate TestServer, as sub-requests run concurrently
+	err = router.Register(http.MethodPost, "/restricted", (&TestServer{}).DoThingValueArgs, WithMiddleware(NewBasicAuthMiddleware("user", "user")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/upper", strings.ToUpper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/list", func() TestOutput {
+		return TestOutput{Output: "list"}
+	}, WithName("list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/boom", func() TestOutput {
+		panic("boom")
+	}, WithName("boom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.EnableBatch("/batch", WithBatchConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[
+		{"method": "POST", "path": "/thing", "body": {"input": "yo"}},
+		{"method": "POST", "path": "/restricted", "body": {"input": "yo"}},
+		{"method": "POST", "path": "/upper", "headers": {"Content-Type": "text/plain"}, "body": "yo"},
+		{"method": "GET", "path": "/missing"},
+		{"method": "POST", "path": "/batch", "body": []},
+		{"method": "POST", "path": "/batch?x=1", "body": []},
+		{"method": "GET", "path": "/list"},
+		{"method": "POST", "path": "/boom"}
+	]`))
+	req.SetBasicAuth("user", "user")
+
+	router.ServeHTTP(w, req)
+
+	expected := `[` +
+		`{"status":200,"headers":{"Content-Type":["application/json"]},"body":{"output":"hi"}},` +
+		`{"status":200,"headers":{"Content-Type":["application/json"]},"body":{"output":"hi"}},` +
+		`{"status":200,"headers":{"Content-Type":["text/plain"]},"body":"YO"},` +
+		`{"status":404,"headers":{"Content-Type":["text/plain; charset=utf-8"],"X-Content-Type-Options":["nosniff"]},"body":"404 page not found\n"},` +
+		`{"status":400,"body":{"error":"autoroute: batches can't be nested"}},` +
+		`{"status":400,"body":{"error":"autoroute: batches can't be nested"}},` +
+		`{"status":200,"headers":{"Content-Type":["application/json"]},"body":{"output":"list"}},` +
+		`{"status":500,"body":{"error":"autoroute: sub-request panicked: boom"}}` +
+		`]`
+	diffJSON(t, expected, w.Body.String())
+}
+
+func TestBatchTooManyRequests(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.EnableBatch("/batch", WithBatchMaxRequests(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[
+		{"method": "GET", "path": "/a"},
+		{"method": "GET", "path": "/b"}
+	]`))
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestBatchSequentialPanic(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/boom", func() TestOutput {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.EnableBatch("/batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[
+		{"method": "POST", "path": "/boom"},
+		{"method": "GET", "path": "/missing"}
+	]`))
+
+	router.ServeHTTP(w, req)
+
+	expected := `[` +
+		`{"status":500,"body":{"error":"autoroute: sub-request panicked: boom"}},` +
+		`{"status":404,"headers":{"Content-Type":["text/plain; charset=utf-8"],"X-Content-Type-Options":["nosniff"]},"body":"404 page not found\n"}` +
+		`]`
+	diffJSON(t, expected, w.Body.String())
+}
@@ -0,0 +1,99 @@
+package autoroute
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// A Blob is a binary response, such as a PDF or an image, which is streamed back to
+// the client as is instead of being encoded by the codec. Functions can return a Blob,
+// a *Blob, or any io.Reader directly.
+// When Reader is also an io.Seeker, Range and conditional requests are supported.
+type Blob struct {
+	Reader io.Reader
+
+	// ContentType defaults to application/octet-stream, or to a sniffed type when Reader
+	// is an io.Seeker
+	ContentType string
+	// Filename, when set, is sent in a Content-Disposition header so browsers download the blob
+	Filename string
+	// Size, when positive, is sent as the Content-Length of a Reader that can't seek
+	Size int64
+	// ModTime, when set, is sent as Last-Modified and used for conditional requests
+	ModTime time.Time
+}
+
+var blobType = reflect.TypeOf(Blob{})
+var blobPtrType = reflect.TypeOf(&Blob{})
+var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+
+// isBlobType reports whether values of t are written with writeBlob
+func isBlobType(t reflect.Type) bool {
+	return t == blobType || t == blobPtrType || t.Implements(readerType)
+}
+
+// writeBlob streams a Blob, *Blob or io.Reader value to w
+func writeBlob(w http.ResponseWriter, r *http.Request, v reflect.Value) {
+	var b Blob
+	switch x := v.Interface().(type) {
+	case Blob:
+		b = x
+	case *Blob:
+		if x != nil {
+			b = *x
+		}
+	case io.Reader:
+		b.Reader = x
+	}
+
+	if b.Reader == nil {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if rc, ok := b.Reader.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	if b.ContentType != "" {
+		w.Header().Set("Content-Type", b.ContentType)
+	} else {
+		// ServeContent only sniffs when no type is set
+		w.Header().Del("Content-Type")
+	}
+
+	if b.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": b.Filename,
+		}))
+	}
+
+	if rs, ok := b.Reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, b.Filename, b.ModTime, rs)
+		return
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	if !b.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", b.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if b.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(b.Size, 10))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	io.Copy(w, b.Reader)
+}
@@ -0,0 +1,119 @@
+package autoroute
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testReadCloser struct {
+	io.Reader
+	closed bool
+}
+
+func (trc *testReadCloser) Close() error {
+	trc.closed = true
+	return nil
+}
+
+func TestBlobRange(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func() (*Blob, error) {
+		return &Blob{
+			Reader:      bytes.NewReader([]byte("%PDF-1.4 pretend this is a pdf")),
+			ContentType: "application/pdf",
+			Filename:    "report.pdf",
+		}, nil
+	}, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Range", "bytes=0-7")
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", w.Code)
+	}
+
+	if w.Body.String() != "%PDF-1.4" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=report.pdf` {
+		t.Fatalf("unexpected content disposition %q", cd)
+	}
+}
+
+func TestBlobReader(t *testing.T) {
+	t.Parallel()
+
+	body := &testReadCloser{Reader: strings.NewReader("some bytes")}
+	handler, err := NewHandler(func() io.ReadCloser {
+		return body
+	}, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	handler.ServeHTTP(w, req)
+
+	b, _ := ioutil.ReadAll(w.Result().Body)
+	if string(b) != "some bytes" {
+		t.Fatalf("unexpected body %q", b)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	if !body.closed {
+		t.Fatal("did not close the reader")
+	}
+}
+
+func TestBlobSize(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func() Blob {
+		return Blob{
+			Reader:      ioutil.NopCloser(strings.NewReader("a,b\n")),
+			ContentType: "text/csv",
+			Size:        4,
+		}
+	}, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	handler.ServeHTTP(w, req)
+
+	if cl := w.Header().Get("Content-Length"); cl != "4" {
+		t.Fatalf("unexpected content length %q", cl)
+	}
+
+	if w.Body.String() != "a,b\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
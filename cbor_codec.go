@@ -0,0 +1,121 @@
+package autoroute
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/autonaut/autoroute/internal/cbor"
+)
+
+// CBORCodec implements autoroute functionality for the mime type application/cbor (RFC 8949)
+// and supports exactly the same function layouts as JSONCodec.
+// time.Time values are encoded as epoch based date/times (tag 1) and decoded from either
+// tag 0 or tag 1, and math/big.Int values are encoded as plain integers when they fit
+// and as bignums (tags 2 and 3) otherwise.
+// Use NewCBORCodec to enable deterministic encoding or tune the decoding limits.
+var CBORCodec Codec = NewCBORCodec()
+
+type CBOROption func(cc *cborCodec)
+
+// WithCBORDeterministic makes the codec use the core deterministic encoding of
+// RFC 8949 section 4.2.1, so equal values always produce identical bytes
+func WithCBORDeterministic() CBOROption {
+	return func(cc *cborCodec) {
+		cc.deterministic = true
+	}
+}
+
+// WithCBORMaxNestedLevels limits how deeply arrays, maps and tags may be nested
+// in a request body
+func WithCBORMaxNestedLevels(n int) CBOROption {
+	return func(cc *cborCodec) {
+		cc.maxNestedLevels = n
+	}
+}
+
+// WithCBORMaxItems limits the number of elements in a single array or pairs in a
+// single map of a request body
+func WithCBORMaxItems(n int) CBOROption {
+	return func(cc *cborCodec) {
+		cc.maxItems = n
+	}
+}
+
+// NewCBORCodec creates a Codec for application/cbor
+func NewCBORCodec(opts ...CBOROption) Codec {
+	cc := cborCodec{
+		maxNestedLevels: cbor.DefaultMaxNestedLevels,
+		maxItems:        cbor.DefaultMaxItems,
+	}
+
+	for _, opt := range opts {
+		opt(&cc)
+	}
+
+	return cc
+}
+
+type cborCodec struct {
+	deterministic   bool
+	maxNestedLevels int
+	maxItems        int
+}
+
+func (cc cborCodec) Mime() string {
+	return "application/cbor"
+}
+
+func (cc cborCodec) ValidFn(fn reflect.Value) error {
+	return validValueFn(fn)
+}
+
+func (cc cborCodec) HandleRequest(cra *CodecRequestArgs) {
+	handleValueRequest(cc, cra)
+}
+
+func (cc cborCodec) encode(w io.Writer, v interface{}) error {
+	enc := cbor.NewEncoder(w)
+	enc.Deterministic = cc.deterministic
+
+	return enc.Encode(v)
+}
+
+func (cc cborCodec) decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error) {
+	if body == nil {
+		// an empty map
+		body = ioutil.NopCloser(bytes.NewReader([]byte{0xa0}))
+	}
+
+	var object reflect.Value
+
+	switch inArg.Kind() {
+	case reflect.Struct:
+		object = newReflectType(inArg)
+	case reflect.Ptr:
+		object = newReflectType(inArg)
+	default:
+		return reflect.Value{}, ErrDecodeFailure
+	}
+
+	dec := cbor.NewDecoder(io.LimitReader(body, maxSizeBytes))
+	dec.MaxNestedLevels = cc.maxNestedLevels
+	dec.MaxItems = cc.maxItems
+	dec.DisallowUnknownFields = true
+
+	err := dec.Decode(object.Interface())
+	if err != nil {
+		if err == io.EOF {
+			return reflect.Value{}, ErrDecodeFailure
+		}
+
+		return reflect.Value{}, err
+	}
+
+	if inArg.Kind() == reflect.Struct {
+		return object.Elem(), nil
+	}
+
+	return object, nil
+}
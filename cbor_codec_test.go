@@ -0,0 +1,95 @@
+package autoroute
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/autonaut/autoroute/internal/cbor"
+)
+
+func newCBORRequest(t *testing.T, v interface{}) *http.Request {
+	b, err := cbor.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/cbor")
+	return req
+}
+
+func TestCBORHandlerBasic(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingAllArgs, WithCodec(CBORCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCBORRequest(t, map[string]string{"input": "yo"}))
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/cbor" {
+		t.Fatalf("expected application/cbor, got %s", ct)
+	}
+
+	var out TestOutput
+	err = cbor.Unmarshal(w.Body.Bytes(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Output != "hi" {
+		t.Fatalf("did not encode output properly, got %+v", out)
+	}
+
+	if ts.input != "yo" {
+		t.Fatal("did not decode input properly")
+	}
+}
+
+func TestCBORHandlerDeterministic(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingNoInputArgsMapOutput, WithCodec(NewCBORCodec(WithCBORDeterministic())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", nil)
+	req.Header.Set("Content-Type", "application/cbor")
+
+	handler.ServeHTTP(w, req)
+
+	// {"output": "hi"}
+	if got := hex.EncodeToString(w.Body.Bytes()); got != "a1666f7574707574626869" {
+		t.Fatalf("unexpected encoding %s", got)
+	}
+}
+
+func TestCBORHandlerLimits(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThing, WithCodec(NewCBORCodec(WithCBORMaxNestedLevels(2))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newCBORRequest(t, map[string]interface{}{
+		"input": []interface{}{[]interface{}{"too deep"}},
+	}))
+
+	diffJSON(t, `{"error":"cbor: exceeded max nested levels"}`, w.Body.String())
+
+	if ts.requests != 0 {
+		t.Fatal("called function with an invalid body")
+	}
+}
@@ -2,11 +2,15 @@ package autoroute
 
 import (
 	"errors"
+	"io"
 	"net/http"
 	"reflect"
+
+	"github.com/autonaut/autoroute/internal/keysigner"
 )
 
 var (
+	// Deprecated: functions can take any number of injected args, see ErrMultipleBodyArgs
 	ErrTooManyInputArgs  = errors.New("autoroute: a function can only have up to three input args")
 	ErrTooManyOutputArgs = errors.New("autoroute: a function can only have up to two output args")
 )
@@ -34,4 +38,204 @@ type CodecRequestArgs struct {
 	InputArgCount, OutputArgCount int
 
 	MaxSizeBytes int64
+
+	// where each arg comes from, planned by NewHandler
+	args []argSpec
+	// set by WithSignedCookies
+	cookieSigner *keysigner.KeySigner
+}
+
+// a valueCodec is a Codec that only knows how to turn a request body into a single
+// value and a single value back into a response body. Codecs implementing it share
+// the function layout documented on JSONCodec.
+type valueCodec interface {
+	Codec
+
+	decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error)
+	encode(w io.Writer, v interface{}) error
+}
+
+// a bodyDecoder decodes a request body into a new value of type inArg
+type bodyDecoder func(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error)
+
+// a bodyEncoder writes a single value to a response body
+type bodyEncoder func(w io.Writer, v interface{}) error
+
+// validValueFn checks a function fits the layout shared by all valueCodecs. Its args
+// are checked by NewHandler, which knows about providers.
+func validValueFn(fn reflect.Value) error {
+	outputArgCount := fn.Type().NumOut()
+	if outputArgCount > 2 {
+		return ErrTooManyOutputArgs
+	}
+
+	return nil
+}
+
+// handleValueRequest decodes the input args with vc, calls the handler function and
+// encodes its output with vc
+func handleValueRequest(vc valueCodec, cra *CodecRequestArgs) {
+	callArgs, err := buildCallArgs(cra, vc.decode)
+	if err != nil {
+		writeCallArgsError(cra.ResponseWriter, cra.ErrorHandler, err)
+		return
+	}
+
+	outputValues := cra.HandlerFn.Call(callArgs)
+	cra.ResponseWriter.Header().Set("Content-Type", vc.Mime())
+	writeOutputs(cra, outputValues, vc.encode)
+}
+
+// buildCallArgs resolves every arg of the handler function for a request, decoding
+// the body arg with decode
+func buildCallArgs(cra *CodecRequestArgs, decode bodyDecoder) ([]reflect.Value, error) {
+	decode = validatingDecoder(decode)
+
+	args := cra.args
+	if args == nil && cra.InputArgCount > 0 {
+		// CodecRequestArgs built outside of a Handler
+		var err error
+		args, err = planArgs(cra.HandlerType, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	callArgs := make([]reflect.Value, len(args))
+	for i, spec := range args {
+		if spec.source != argBody {
+			v, err := cra.argValue(spec)
+			if err != nil {
+				return nil, err
+			}
+
+			callArgs[i] = v
+			continue
+		}
+
+		if cra.Request.Body == nil {
+			return nil, errors.New("autoroute: request requires a body")
+		}
+
+		v, err := decode(cra.HandlerType.In(i), cra.Request.Body, cra.MaxSizeBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		callArgs[i] = v
+	}
+
+	return callArgs, nil
+}
+
+// an injectError is a provider failing to build an arg
+type injectError struct {
+	err error
+}
+
+func (ie *injectError) Error() string {
+	return ie.err.Error()
+}
+
+func (ie *injectError) Unwrap() error {
+	return ie.err
+}
+
+// writeCallArgsError reports a failure of buildCallArgs. Providers fail like
+// middleware does, with the status of a MiddlewareError or else a 500.
+func writeCallArgsError(w http.ResponseWriter, errorHandler ErrorHandler, err error) {
+	if ie, ok := err.(*injectError); ok {
+		writeMiddlewareError(w, errorHandler, ie.err)
+		return
+	}
+
+	errorHandler.Handle(w, reflect.ValueOf(err))
+}
+
+// validatingDecoder completes the defaults of every value decode returns, then
+// validates it
+func validatingDecoder(decode bodyDecoder) bodyDecoder {
+	return func(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error) {
+		v, err := decode(inArg, body, maxSizeBytes)
+		if err != nil {
+			return v, err
+		}
+
+		applyDecodedDefaults(v, false)
+
+		err = validateInput(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		return v, nil
+	}
+}
+
+func writeOutputs(cra *CodecRequestArgs, outputValues []reflect.Value, encode bodyEncoder) {
+	switch cra.OutputArgCount {
+	case 2:
+		// if err == nil
+		if outputValues[1].IsNil() {
+			writeValue(cra, outputValues[0], encode)
+			return
+		}
+
+		if outputValues[1].Kind() == reflect.Interface {
+			if outputValues[1].Type().ConvertibleTo(errorType) {
+				cra.ErrorHandler.Handle(cra.ResponseWriter, outputValues[1])
+				return
+			}
+		}
+	case 1:
+		if outputValues[0].Kind() == reflect.Interface {
+			if outputValues[0].Type().ConvertibleTo(errorType) {
+				cra.ErrorHandler.Handle(cra.ResponseWriter, outputValues[0])
+				return
+			}
+		}
+
+		writeValue(cra, outputValues[0], encode)
+	case 0:
+		cra.ResponseWriter.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeValue writes a successful output value, applying any ResponseMeta it embeds
+func writeValue(cra *CodecRequestArgs, v reflect.Value, encode bodyEncoder) {
+	status, err := writeResponseMeta(cra.ResponseWriter, findResponseMeta(v), cra.cookieSigner)
+	if err != nil {
+		panic(err)
+	}
+
+	if isBlobType(v.Type()) {
+		writeBlob(cra.ResponseWriter, cra.Request, v)
+		return
+	}
+
+	if status != 0 {
+		cra.ResponseWriter.WriteHeader(status)
+	}
+
+	err = encode(cra.ResponseWriter, v.Interface())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// outputResult splits the output values of a function into its result and error
+func outputResult(outputValues []reflect.Value) (interface{}, error) {
+	var result interface{}
+	for _, ov := range outputValues {
+		if ov.Kind() == reflect.Interface && ov.Type().ConvertibleTo(errorType) {
+			if !ov.IsNil() {
+				return nil, ov.Interface().(error)
+			}
+			continue
+		}
+
+		result = ov.Interface()
+	}
+
+	return result, nil
 }
@@ -0,0 +1,103 @@
+package autoroute
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setFromString parses s into v, which must be settable. It understands strings,
+// bools, numbers, time.Duration, anything implementing encoding.TextUnmarshaler
+// (such as time.Time, in RFC 3339), pointers to any of those, and comma separated
+// slices of them.
+func setFromString(v reflect.Value, s string) error {
+	t := v.Type()
+	if t.Kind() == reflect.Ptr {
+		elem := reflect.New(t.Elem())
+		err := setFromString(elem.Elem(), s)
+		if err != nil {
+			return err
+		}
+
+		v.Set(elem)
+		return nil
+	}
+
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		ptr := reflect.New(t)
+		err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		if err != nil {
+			return err
+		}
+
+		v.Set(ptr.Elem())
+		return nil
+	}
+
+	if t == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+
+		slice := reflect.MakeSlice(t, len(parts), len(parts))
+		for i, part := range parts {
+			err := setFromString(slice.Index(i), strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("autoroute: can't set a %s from a string", t)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package autoroute
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/autonaut/autoroute/internal/keysigner"
+)
+
+// Cookies holds the value of each request cookie. With WithSignedCookies, cookies
+// without a valid signature are left out.
+type Cookies map[string]string
+
+func (c Cookies) Get(name string) string {
+	return c[name]
+}
+
+var cookiesType = reflect.TypeOf(make(Cookies))
+
+// WithSignedCookies signs every cookie set through ResponseMeta with key, and only
+// lets functions see request cookies carrying a valid signature, whether through
+// Cookies or a `cookie:"name"` tagged field. Signatures cover the cookie's name, so a
+// value signed for one cookie can't be passed off as another.
+func WithSignedCookies(key string) HandlerOption {
+	return func(h *Handler) {
+		h.cookieSigner = keysigner.NewKeySigner(key)
+	}
+}
+
+// ResponseMeta lets a function set cookies, headers and the status code of its
+// response. Embed it in an output type and call its methods on the value being
+// returned; it's never encoded into the body.
+type ResponseMeta struct {
+	cookies []*http.Cookie
+	header  http.Header
+	status  int
+}
+
+// SetCookie adds a Set-Cookie header to the response
+func (rm *ResponseMeta) SetCookie(c *http.Cookie) {
+	rm.cookies = append(rm.cookies, c)
+}
+
+// SetHeader sets a response header, replacing any value set before
+func (rm *ResponseMeta) SetHeader(key, value string) {
+	if rm.header == nil {
+		rm.header = make(http.Header)
+	}
+	rm.header.Set(key, value)
+}
+
+// SetStatus replaces the usual 200 status code
+func (rm *ResponseMeta) SetStatus(code int) {
+	rm.status = code
+}
+
+func (rm *ResponseMeta) responseMeta() *ResponseMeta {
+	return rm
+}
+
+// a responseMetaHolder is any type embedding ResponseMeta
+type responseMetaHolder interface {
+	responseMeta() *ResponseMeta
+}
+
+// findResponseMeta returns the ResponseMeta embedded in an output value, if any
+func findResponseMeta(v reflect.Value) *ResponseMeta {
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()) {
+		return nil
+	}
+
+	if holder, ok := v.Interface().(responseMetaHolder); ok {
+		return holder.responseMeta()
+	}
+
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(reflect.TypeOf((*responseMetaHolder)(nil)).Elem()) {
+		// a value output, whose methods need an addressable copy
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		return ptr.Interface().(responseMetaHolder).responseMeta()
+	}
+
+	return nil
+}
+
+// writeResponseMeta applies the headers and cookies of rm to w, signing cookies
+// with signer when it's set, and returns the status to respond with
+func writeResponseMeta(w http.ResponseWriter, rm *ResponseMeta, signer *keysigner.KeySigner) (int, error) {
+	if rm == nil {
+		return 0, nil
+	}
+
+	for k, v := range rm.header {
+		w.Header()[k] = v
+	}
+
+	for _, c := range rm.cookies {
+		if signer != nil {
+			signed, err := signCookie(signer, c.Name, c.Value)
+			if err != nil {
+				return 0, err
+			}
+
+			copied := *c
+			copied.Value = signed
+			c = &copied
+		}
+
+		http.SetCookie(w, c)
+	}
+
+	return rm.status, nil
+}
+
+// cookies reads the request's cookies, verifying them when the handler signs cookies
+func (cra *CodecRequestArgs) cookies() Cookies {
+	cookies := make(Cookies)
+	for _, c := range cra.Request.Cookies() {
+		if _, ok := cookies[c.Name]; ok {
+			// the first, most specific, cookie wins
+			continue
+		}
+
+		value := c.Value
+		if cra.cookieSigner != nil {
+			verified, err := verifyCookie(cra.cookieSigner, c.Name, value)
+			if err != nil {
+				continue
+			}
+			value = verified
+		}
+
+		cookies[c.Name] = value
+	}
+
+	return cookies
+}
+
+// signCookie signs name=value, returning the signed value without the name, which the
+// cookie carries anyway
+func signCookie(signer *keysigner.KeySigner, name, value string) (string, error) {
+	signed, err := signer.Sign(name + "=" + value)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(signed, name+"="), nil
+}
+
+// verifyCookie checks a value signed by signCookie for the cookie called name
+func verifyCookie(signer *keysigner.KeySigner, name, value string) (string, error) {
+	verified, err := signer.Verify(name + "=" + value)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(verified, name+"="), nil
+}
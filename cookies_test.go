@@ -0,0 +1,94 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type SessionInput struct {
+	Session string `cookie:"session" validate:"required"`
+	Theme   string `cookie:"theme" default:"light"`
+}
+
+type LoginOutput struct {
+	ResponseMeta
+
+	User string `json:"user"`
+}
+
+func TestCookies(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithSignedCookies("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/login", func(ti TestInput) LoginOutput {
+		out := LoginOutput{User: ti.Input}
+		out.SetCookie(&http.Cookie{Name: "session", Value: ti.Input, Path: "/", HttpOnly: true})
+		out.SetHeader("X-Logged-In", "yes")
+		out.SetStatus(http.StatusCreated)
+		return out
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/me", func(si *SessionInput, c Cookies) TestOutput {
+		return TestOutput{Output: si.Session + " " + si.Theme + " " + c.Get("session")}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"input": "ian@example.com"}`))
+	req.Header.Set(MimeTypeHeader, "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated || w.Header().Get("X-Logged-In") != "yes" {
+		t.Fatalf("expected a 201 with a header, got %d %v", w.Code, w.Header())
+	}
+
+	// ResponseMeta isn't part of the body
+	diffJSON(t, `{"user":"ian@example.com"}`, w.Body.String())
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, "ian@example.com.") {
+		t.Fatalf("expected a signed session cookie, got %v", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.AddCookie(cookies[0])
+	router.ServeHTTP(w, req)
+
+	diffJSON(t, `{"output":"ian@example.com light ian@example.com"}`, w.Body.String())
+
+	// unsigned cookies are ignored
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "ian@example.com"})
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422, got %d", w.Code)
+	}
+
+	diffJSON(t, `{"error":"autoroute: invalid input: session is required","fields":[{"field":"session","message":"is required"}]}`, w.Body.String())
+
+	// a signed value can't be replayed under another cookie's name
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.AddCookie(&http.Cookie{Name: "session", Value: cookies[0].Value})
+	req.AddCookie(&http.Cookie{Name: "theme", Value: cookies[0].Value})
+	router.ServeHTTP(w, req)
+
+	diffJSON(t, `{"output":"ian@example.com light ian@example.com"}`, w.Body.String())
+}
@@ -0,0 +1,256 @@
+package autoroute
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSMiddleware lets browsers on other origins call a Router. Add it with Router.Use,
+// where it answers preflight OPTIONS requests itself, allowing the methods registered
+// at the requested path, and adds CORS headers to the responses of allowed origins.
+// Requests without an Origin header pass straight through.
+type CORSMiddleware struct {
+	router *Router
+
+	origins          []string
+	originFunc       func(origin string) bool
+	allowedHeaders   []string
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+type CORSOption func(cm *CORSMiddleware)
+
+// WithCORSOrigins allows origins such as "https://example.com", any single level
+// subdomain with "https://*.example.com", or any origin with "*"
+func WithCORSOrigins(origins ...string) CORSOption {
+	return func(cm *CORSMiddleware) {
+		cm.origins = append(cm.origins, origins...)
+	}
+}
+
+// WithCORSOriginFunc allows any origin fn returns true for, as well as those given to
+// WithCORSOrigins
+func WithCORSOriginFunc(fn func(origin string) bool) CORSOption {
+	return func(cm *CORSMiddleware) {
+		cm.originFunc = fn
+	}
+}
+
+// WithCORSAllowedHeaders sets the request headers browsers may send, which is only
+// Content-Type by default. "*" allows any header.
+func WithCORSAllowedHeaders(headers ...string) CORSOption {
+	return func(cm *CORSMiddleware) {
+		cm.allowedHeaders = headers
+	}
+}
+
+// WithCORSExposedHeaders sets the response headers scripts may read beyond the simple ones
+func WithCORSExposedHeaders(headers ...string) CORSOption {
+	return func(cm *CORSMiddleware) {
+		cm.exposedHeaders = headers
+	}
+}
+
+// WithCORSCredentials lets browsers send cookies and basic auth. Responses then name
+// the origin rather than "*". Origins only allowed by "*" never get credentials, as
+// that would let any site act as the user.
+func WithCORSCredentials() CORSOption {
+	return func(cm *CORSMiddleware) {
+		cm.allowCredentials = true
+	}
+}
+
+// WithCORSMaxAge sets how long browsers may cache the answer to a preflight
+func WithCORSMaxAge(d time.Duration) CORSOption {
+	return func(cm *CORSMiddleware) {
+		cm.maxAge = d
+	}
+}
+
+// NewCORSMiddleware creates CORS middleware for ro, which it asks for the methods
+// registered at each path. With no origins allowed every cross origin request is refused.
+func NewCORSMiddleware(ro *Router, opts ...CORSOption) *CORSMiddleware {
+	cm := &CORSMiddleware{
+		router:         ro,
+		allowedHeaders: []string{MimeTypeHeader},
+	}
+
+	for _, opt := range opts {
+		opt(cm)
+	}
+
+	return cm
+}
+
+func (cm *CORSMiddleware) Before(r *http.Request, h *Handler) error {
+	return nil
+}
+
+func (cm *CORSMiddleware) Around(h *Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			cm.preflight(w, r, origin)
+			return
+		}
+
+		if allowed, anyOrigin := cm.originAllowed(origin); allowed {
+			cm.allowOrigin(w, origin, anyOrigin)
+
+			if len(cm.exposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(cm.exposedHeaders, ", "))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a preflight request, leaving out every CORS header when the origin,
+// method or headers asked for aren't allowed so the browser refuses the real request
+func (cm *CORSMiddleware) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	methods := cm.router.methods(r.URL.Path)
+	if len(methods) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	requested := requestedHeaders(r.Header.Get("Access-Control-Request-Headers"))
+	allowed, anyOrigin := cm.originAllowed(origin)
+	if !allowed || !containsFold(methods, method) || !cm.headersAllowed(requested) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	cm.allowOrigin(w, origin, anyOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(requested) > 0 {
+		// the list asked for has been checked, so it can be echoed back
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+
+	if cm.maxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cm.maxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets the headers allowing origin, anyOrigin when it was only allowed by "*"
+func (cm *CORSMiddleware) allowOrigin(w http.ResponseWriter, origin string, anyOrigin bool) {
+	if anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	if cm.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
+
+// originAllowed reports whether origin is allowed, and whether only by "*"
+func (cm *CORSMiddleware) originAllowed(origin string) (allowed bool, anyOrigin bool) {
+	for _, pattern := range cm.origins {
+		if pattern == "*" {
+			anyOrigin = true
+			continue
+		}
+
+		if strings.EqualFold(pattern, origin) || subdomainMatch(strings.ToLower(pattern), strings.ToLower(origin)) {
+			return true, false
+		}
+	}
+
+	if cm.originFunc != nil && cm.originFunc(origin) {
+		return true, false
+	}
+
+	return anyOrigin, anyOrigin
+}
+
+// subdomainMatch matches a "https://*.example.com" pattern, whose star stands in for
+// a single, non-empty, subdomain label
+func subdomainMatch(pattern, origin string) bool {
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return false
+	}
+
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if !strings.HasPrefix(suffix, ".") || len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	label := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(label, ".:")
+}
+
+func (cm *CORSMiddleware) headersAllowed(headers []string) bool {
+	if containsFold(cm.allowedHeaders, "*") {
+		return true
+	}
+
+	for _, h := range headers {
+		if !containsFold(cm.allowedHeaders, h) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestedHeaders splits an Access-Control-Request-Headers list
+func requestedHeaders(list string) []string {
+	var headers []string
+	for _, h := range strings.Split(list, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" {
+			headers = append(headers, h)
+		}
+	}
+
+	return headers
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// methods lists the methods registered at path, or every method for a mounted handler
+func (ro *Router) methods(path string) []string {
+	var methods []string
+	_, _, mounted := ro.mounted(path)
+	for method, routes := range ro.routeMap {
+		if _, ok := routes[path]; ok || mounted {
+			methods = append(methods, method)
+		}
+	}
+
+	sort.Strings(methods)
+	return methods
+}
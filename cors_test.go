@@ -0,0 +1,192 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		err = router.Register(method, "/test", func(ti TestInput) TestOutput {
+			return TestOutput{Output: ti.Input}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router.Use(NewCORSMiddleware(router,
+		WithCORSOrigins("https://app.example.com", "https://*.example.org", "https://*example.net"),
+		WithCORSOriginFunc(func(origin string) bool { return strings.HasSuffix(origin, ".test") }),
+		WithCORSAllowedHeaders("Content-Type", "X-Api-Key"),
+		WithCORSExposedHeaders("X-Request-Id"),
+		WithCORSCredentials(),
+		WithCORSMaxAge(10*time.Minute),
+	))
+
+	tcs := []struct {
+		name, origin, method, headers string
+		status                        int
+		expected                      map[string]string
+	}{
+		{"exact origin", "https://app.example.com", http.MethodPost, "content-type, x-api-key", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Methods":     "DELETE, POST",
+			"Access-Control-Allow-Headers":     "content-type, x-api-key",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "600",
+		}},
+		{"wildcard subdomain", "https://a.example.org", http.MethodDelete, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "https://a.example.org",
+			"Access-Control-Allow-Headers": "",
+		}},
+		{"nested subdomain", "https://a.b.example.org", http.MethodDelete, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"wildcard port", "https://a.example.org:8443", http.MethodDelete, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"wildcard without a dot", "https://evilexample.net", http.MethodDelete, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"origin func", "http://localhost.test", http.MethodPost, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "http://localhost.test",
+		}},
+		{"bare wildcard domain", "https://example.org", http.MethodPost, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"unknown origin", "https://evil.com", http.MethodPost, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"unregistered method", "https://app.example.com", http.MethodPut, "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"unknown header", "https://app.example.com", http.MethodPost, "x-other", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+	}
+
+	for _, tc := range tcs {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+		req.Header.Set("Origin", tc.origin)
+		req.Header.Set("Access-Control-Request-Method", tc.method)
+		if tc.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", tc.headers)
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.status, w.Code)
+		}
+
+		for k, v := range tc.expected {
+			if got := w.Header().Get(k); got != v {
+				t.Fatalf("%s: expected %s %q, got %q", tc.name, k, v, got)
+			}
+		}
+	}
+
+	// preflights for unknown routes aren't answered
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/missing", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected a 404, got %d", w.Code)
+	}
+
+	// the real request
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set(MimeTypeHeader, "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("expected CORS headers, got %v", w.Header())
+	}
+
+	diffJSON(t, `{"output":"yo"}`, w.Body.String())
+}
+
+func TestCORSMiddlewareAnyOrigin(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Mount("/static/", http.FileServer(http.Dir(".")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Use(NewCORSMiddleware(router, WithCORSOrigins("*")))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/static/app.js", nil)
+	req.Header.Set("Origin", "https://anywhere.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Methods") != "DELETE, GET, PATCH, POST, PUT" {
+		t.Fatalf("expected any origin and method, got %v", w.Header())
+	}
+}
+
+func TestCORSMiddlewareAnyOriginCredentials(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/test", func(ti TestInput) TestOutput {
+		return TestOutput{Output: ti.Input}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Use(NewCORSMiddleware(router, WithCORSOrigins("*", "https://app.example.com"), WithCORSCredentials()))
+
+	tcs := []struct {
+		origin, allowOrigin, credentials string
+	}{
+		// any site could otherwise act as the user
+		{"https://evil.com", "*", ""},
+		{"https://app.example.com", "https://app.example.com", "true"},
+	}
+
+	for _, tc := range tcs {
+		for _, method := range []string{http.MethodOptions, http.MethodPost} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, "/test", strings.NewReader(`{"input": "yo"}`))
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set(MimeTypeHeader, "application/json")
+			if method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			router.ServeHTTP(w, req)
+
+			if w.Header().Get("Access-Control-Allow-Origin") != tc.allowOrigin || w.Header().Get("Access-Control-Allow-Credentials") != tc.credentials {
+				t.Fatalf("%s %s: unexpected headers %v", method, tc.origin, w.Header())
+			}
+		}
+	}
+}
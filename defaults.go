@@ -0,0 +1,125 @@
+package autoroute
+
+import (
+	"reflect"
+)
+
+// applyDefaults sets the zero fields of a newly allocated input, and of the structs
+// it embeds by value, from their default tags. Inputs are decoded over the result,
+// so anything the request sets explicitly wins. Structs the decoder allocates are
+// left to applyDecodedDefaults.
+func applyDefaults(v reflect.Value) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	rules, err := structRules(v.Type())
+	if err != nil {
+		// NewHandler has already reported this
+		return
+	}
+
+	for _, fr := range rules {
+		fv := v.Field(fr.index)
+		if fr.hasDefault && fv.IsZero() {
+			fv.Set(copyDefault(fr.defaultValue))
+		}
+
+		if fv.Kind() == reflect.Struct {
+			applyDefaults(fv.Addr())
+		}
+	}
+}
+
+// applyDecodedDefaults sets the defaults of the structs a decoder allocated below v,
+// behind pointers or in slices, arrays and maps, which applyDefaults couldn't reach
+// before decoding. As they weren't prefilled, their fields are taken to be left out
+// when they're zero. allocated is whether v itself is such a struct.
+func applyDecodedDefaults(v reflect.Value, allocated bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			applyDecodedDefaults(v.Elem(), allocated)
+		}
+	case reflect.Struct:
+		if !v.CanAddr() {
+			return
+		}
+
+		if allocated {
+			applyDefaults(v.Addr())
+		}
+
+		rules, err := structRules(v.Type())
+		if err != nil {
+			// NewHandler has already reported this
+			return
+		}
+
+		for _, fr := range rules {
+			// structs held by value were filled along with v, those behind pointers
+			// were allocated by the decoder
+			fv := v.Field(fr.index)
+			applyDecodedDefaults(fv, fv.Kind() == reflect.Ptr)
+		}
+	case reflect.Slice, reflect.Array:
+		if !holdsStructs(v.Type().Elem()) {
+			return
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			applyDecodedDefaults(v.Index(i), true)
+		}
+	case reflect.Map:
+		if !holdsStructs(v.Type().Elem()) {
+			return
+		}
+
+		iter := v.MapRange()
+		for iter.Next() {
+			// map values can't be set in place, so fill a copy
+			elem := reflect.New(iter.Value().Type()).Elem()
+			elem.Set(iter.Value())
+			applyDecodedDefaults(elem, true)
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+}
+
+// holdsStructs is whether values of t can have structs below them
+func holdsStructs(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+
+	return false
+}
+
+// copyDefault copies a parsed default, so inputs never share a slice or pointer
+// with it or with each other
+func copyDefault(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c
+	case reflect.Ptr:
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyDefault(v.Elem()))
+		return c
+	}
+
+	return v
+}
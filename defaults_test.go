@@ -0,0 +1,91 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type SearchPage struct {
+	Size int `json:"size" default:"10"`
+}
+
+type SearchFilter struct {
+	Field string     `json:"field" default:"name"`
+	Op    string     `json:"op" default:"eq"`
+	Page  SearchPage `json:"page"`
+}
+
+type SearchInput struct {
+	Query   string         `json:"query"`
+	Limit   int            `json:"limit" default:"20"`
+	Exact   bool           `json:"exact" default:"true"`
+	Timeout time.Duration  `json:"timeout" default:"1500ms"`
+	Since   time.Time      `json:"since" default:"2020-01-02T03:04:05Z"`
+	Kinds   []string       `json:"kinds" default:"a, b"`
+	Ratio   *float64       `json:"ratio" default:"0.5"`
+	Page    SearchPage     `json:"page"`
+	Filter  *SearchFilter  `json:"filter,omitempty"`
+	Ands    []SearchFilter `json:"ands,omitempty"`
+}
+
+func Search(si *SearchInput) *SearchInput {
+	return si
+}
+
+func TestDefaults(t *testing.T) {
+	t.Parallel()
+
+	h, err := NewHandler(Search, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"omitted",
+			`{"query": "q"}`,
+			`{"query":"q","limit":20,"exact":true,"timeout":1500000000,"since":"2020-01-02T03:04:05Z","kinds":["a","b"],"ratio":0.5,"page":{"size":10}}`,
+		},
+		{
+			"explicit",
+			`{"query": "q", "limit": 0, "exact": false, "timeout": 1, "since": "2021-01-01T00:00:00Z", "kinds": [], "ratio": null, "page": {"size": 5}}`,
+			`{"query":"q","limit":0,"exact":false,"timeout":1,"since":"2021-01-01T00:00:00Z","kinds":[],"ratio":null,"page":{"size":5}}`,
+		},
+		{
+			"nested",
+			`{"query": "q", "limit": 0, "filter": {"op": "ne"}, "ands": [{"field": "age", "page": {}}, {}]}`,
+			`{"query":"q","limit":0,"exact":true,"timeout":1500000000,"since":"2020-01-02T03:04:05Z","kinds":["a","b"],"ratio":0.5,"page":{"size":10},` +
+				`"filter":{"field":"name","op":"ne","page":{"size":10}},` +
+				`"ands":[{"field":"age","op":"eq","page":{"size":10}},{"field":"name","op":"eq","page":{"size":10}}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		req.Header.Set(MimeTypeHeader, "application/json")
+		h.ServeHTTP(w, req)
+
+		diffJSON(t, c.expected, w.Body.String())
+	}
+}
+
+func TestDefaultsBadTag(t *testing.T) {
+	t.Parallel()
+
+	type BadDefault struct {
+		Limit int `default:"many"`
+	}
+
+	_, err := NewHandler(func(bd BadDefault) {}, WithCodec(JSONCodec))
+	if err == nil || !strings.Contains(err.Error(), `invalid default "many"`) {
+		t.Fatalf("expected a bad default error, got %v", err)
+	}
+}
@@ -24,12 +24,23 @@ func (eh ErrorHandler) Handle(w http.ResponseWriter, errorValue reflect.Value) {
 	ehFn.Call([]reflect.Value{reflect.ValueOf(w), errConv})
 }
 
-// DefaultErrorHandler writes json `{"error": "errString"}`
+// DefaultErrorHandler writes json `{"error": "errString"}`, plus a list of
+// `{"field": ..., "message": ...}` under "fields" for a 422 ValidationError
 func DefaultErrorHandler(w http.ResponseWriter, x error) {
+	// errors are always json, whichever codec handled the request
+	w.Header().Set("Content-Type", "application/json")
+
 	if x == ErrDecodeFailure {
 		w.WriteHeader(http.StatusBadRequest)
 	}
 
+	var ve *ValidationError
+	if errors.As(x, &ve) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": ve.Error(), "fields": ve.Fields})
+		return
+	}
+
 	if x == nil {
 		json.NewEncoder(w).Encode(map[string]interface{}{"error": nil})
 		return
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"log"
 	"net/http"
 
@@ -10,52 +9,67 @@ import (
 )
 
 type LoginResponse struct {
-	APIKey string
+	// ResponseMeta lets login set headers and cookies, it's never part of the body
+	autoroute.ResponseMeta
+
+	Username string
+}
+
+type WhoAmIResponse struct {
+	Username string
 }
 
 type SpecialResponse struct {
 	Restricted bool
+	Owner      string
 }
 
 // DoSomethingSpecial is some restricted access.
-// Hypothetically, we could add autoroute.Header as the second argument to this function
-// and then look at the actual x-api-key value itself!
-func DoSomethingSpecial(ctx context.Context) *SpecialResponse {
+// The api key middleware hands us the verified key, so we know who's calling
+func DoSomethingSpecial(ctx context.Context, key *autoroute.APIKey) *SpecialResponse {
 	return &SpecialResponse{
 		Restricted: true,
+		Owner:      key.Owner,
 	}
 }
 
-type apiKeyVerifier struct{}
-
-func (akv *apiKeyVerifier) Before(r *http.Request, h *autoroute.Handler) error {
-	val := r.Header.Get("x-api-key")
-	if val != "hurray!" {
-		return autoroute.MiddlewareError{
-			StatusCode: http.StatusForbidden,
-			Err:        errors.New("invalid api key"),
-		}
-	}
-
-	return nil
-}
-
 func main() {
+	// every use of signing gets its own secret, so nothing signed for one passes for another
 	signedHeaderMiddleware := autoroute.NewSignedHeadersMiddleware([]string{"x-api-key"}, "test-key")
+	cookieKey := "test-cookie-key"
+	// only hashes of the keys handed out are kept
+	keyStore := autoroute.NewMemoryKeyStore()
 
 	login := func(ctx context.Context, input struct {
 		Username string
 		Password string
 	}) (*LoginResponse, error) {
-		// use the middleware directly in our login handler so the value is nice and signed going out
-		signedAPIKey, err := signedHeaderMiddleware.Sign("hurray!")
+		resp := &LoginResponse{
+			Username: input.Username,
+		}
+
+		key, record, err := autoroute.NewAPIKey(input.Username, "special")
 		if err != nil {
 			return nil, err
 		}
+		keyStore.Add(record)
+
+		// the signer middleware signs this on the way out, no need to call Sign
+		resp.SetHeader("x-api-key", key)
+
+		// browsers can use a session cookie instead, signed on the way out by WithSignedCookies
+		resp.SetCookie(&http.Cookie{Name: "session", Value: input.Username, Path: "/", HttpOnly: true})
 
-		return &LoginResponse{
-			APIKey: signedAPIKey,
-		}, nil
+		return resp, nil
+	}
+
+	// whoami only sees the session cookie when its signature is valid
+	whoami := func(session struct {
+		Username string `cookie:"session" validate:"required"`
+	}) *WhoAmIResponse {
+		return &WhoAmIResponse{
+			Username: session.Username,
+		}
 	}
 
 	// applies the JSONCodec to all sub routes
@@ -64,29 +78,41 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// register our two routes
-	r.Register(http.MethodPost, "/login", login)
+	// register our routes
+	r.Register(http.MethodPost, "/login", login,
+		// only signs, as nobody logging in has an api key to verify yet
+		autoroute.WithMiddleware(signedHeaderMiddleware.Signer()),
+		autoroute.WithSignedCookies(cookieKey),
+	)
+	r.Register(http.MethodGet, "/whoami", whoami, autoroute.WithSignedCookies(cookieKey))
 	r.Register(http.MethodPost, "/special", DoSomethingSpecial,
 		// signed header middleware runs first and ensures the api key is signed for this route
 		autoroute.WithMiddleware(signedHeaderMiddleware),
-		// apikeyVerifier gets the clean, unsigned version of this header
-		autoroute.WithMiddleware(&apiKeyVerifier{}),
+		// the api key middleware gets the clean, unsigned version of this header
+		autoroute.WithMiddleware(autoroute.NewAPIKeyMiddleware(keyStore)),
+		autoroute.WithRequiredScopes("special"),
 	)
 
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
 
 // here's the curl guide to how this works
-// ian@zuus ~ % curl -XPOST localhost:8080/login -H 'Content-Type: application/json' -d '{"Username": "ian", "Password": "password"}'
-// {"APIKey":"hurray!.6fe7c33d56bab58e99b494c558720aae045a1fca2cf5902a3bf8ea6a1ae623ae"}
+// ian@zuus ~ % curl -i -XPOST localhost:8080/login -H 'Content-Type: application/json' -d '{"Username": "ian", "Password": "password"}'
+// X-Api-Key: b59c9dc7bfb4bf44.78463f3372cc91a0e30f036e0c90a7ff2b584b33feedea9835de45801ac4808d.acc53f824febac081cea96e74014e0990ba77241392fc87206eaa166b5523a8d
+// {"Username":"ian"}
+
+// the session cookie is signed too
+// ian@zuus ~ % curl -c jar -XPOST localhost:8080/login -H 'Content-Type: application/json' -d '{"Username": "ian", "Password": "password"}'
+// ian@zuus ~ % curl -b jar localhost:8080/whoami -H 'Content-Type: application/json'
+// {"Username":"ian"}
 
 // with no header sent we get rejected
 // ian@zuus ~ % curl -XPOST localhost:8080/special
 // {"error":"invalid token"}
 
 // with an unsigned header we get rejected
-// ian@zuus ~ % curl -XPOST localhost:8080/special -H 'x-api-key: hurray!'
-// {"error":"invalid token"}
+// ian@zuus ~ % curl -XPOST localhost:8080/special -H 'x-api-key: b59c9dc7bfb4bf44.78463f3372cc91a0e30f036e0c90a7ff2b584b33feedea9835de45801ac4808d'
+// {"error":"invalid signature"}
 
-// ian@zuus ~ % curl -XPOST localhost:8080/special -H 'Content-Type: application/json' -H 'x-api-key: hurray!.6fe7c33d56bab58e99b494c558720aae045a1fca2cf5902a3bf8ea6a1ae623ae'
-// {"Restricted":true}
+// ian@zuus ~ % curl -XPOST localhost:8080/special -H 'Content-Type: application/json' -H 'x-api-key: b59c9dc7bfb4bf44.78463f3372cc91a0e30f036e0c90a7ff2b584b33feedea9835de45801ac4808d.acc53f824febac081cea96e74014e0990ba77241392fc87206eaa166b5523a8d'
+// {"Restricted":true,"Owner":"ian"}
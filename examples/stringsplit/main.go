@@ -22,6 +22,11 @@ func SplitString(ssi *SplitStringInput) *SplitStringOutput {
 	}
 }
 
+// SplitStringText does the same thing over text/plain, no wrapper structs required
+func SplitStringText(s string) []string {
+	return strings.Split(s, " ")
+}
+
 func main() {
 	// autoroute includes a powerful Router of its own, that's deeply
 	// integrated with autoroute's handlers and provides many mechanisms
@@ -39,5 +44,12 @@ func main() {
 	}
 	mux.Handle("/", h)
 
+	// the same approach works for plain text, outputting one split per line
+	th, err := autoroute.NewHandler(SplitStringText, autoroute.WithCodec(autoroute.TextCodec))
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux.Handle("/text", th)
+
 	log.Fatal(http.ListenAndServe(":8080", mux))
 }
@@ -3,10 +3,14 @@ package autoroute
 import (
 	"context"
 	"errors"
+	"fmt"
 	"mime"
 	"net/http"
 	"reflect"
 	"runtime"
+	"strings"
+
+	"github.com/autonaut/autoroute/internal/keysigner"
 )
 
 var (
@@ -69,10 +73,27 @@ type Handler struct {
 
 	inputArgCount, outputArgCount int
 
+	// set by WithProvider, map[T]func(*http.Request) (T, error)
+	providers map[reflect.Type]reflect.Value
+	// where each arg of the function comes from
+	args []argSpec
+
 	middlewares []Middleware
+	// how many of middlewares came from the Router, always first
+	routerMiddlewares int
 
 	maxSizeBytes int64
 	errorHandler ErrorHandler
+
+	// set by WithAsync
+	async *asyncRunner
+	// set by WithRequiredScopes and WithRoles
+	requiredScopes, roles []string
+	// set by WithSignedCookies
+	cookieSigner *keysigner.KeySigner
+
+	// the first error from an option, returned by NewHandler
+	optionErr error
 }
 
 // NewHandler creates an http.Handler from a function that fits a codec-specified
@@ -104,6 +125,25 @@ func NewHandler(x interface{}, opts ...HandlerOption) (*Handler, error) {
 		opt(h)
 	}
 
+	if h.optionErr != nil {
+		return nil, h.optionErr
+	}
+
+	args, err := planArgs(h.reflectFnType, h.providers, declaredValues(h.middlewares))
+	if err != nil {
+		return nil, err
+	}
+	h.args = args
+
+	// catch bad validate and default tags on any input now, rather than on a request
+	seen := make(map[reflect.Type]bool)
+	for i := 0; i < inputArgCount; i++ {
+		err := checkInputTags(h.reflectFnType.In(i), seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// prevalidate all loaded codecs
 	for _, codec := range h.mimeToCodec {
 		err := codec.ValidFn(h.reflectFn)
@@ -117,23 +157,156 @@ func NewHandler(x interface{}, opts ...HandlerOption) (*Handler, error) {
 
 const MimeTypeHeader = "Content-Type"
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	for _, mw := range h.middlewares {
-		err := mw.Before(r, h)
-		if err != nil {
-			mwe, ok := err.(MiddlewareError)
-			if ok {
-				w.WriteHeader(mwe.StatusCode)
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
+// setOptionErr records the first error from an option
+func (h *Handler) setOptionErr(err error) {
+	if h.optionErr == nil {
+		h.optionErr = err
+	}
+}
+
+// Name is the name given with WithName, or else the name of the function itself
+// without its package
+func (h *Handler) Name() string {
+	if h.name != "" {
+		return h.name
+	}
+
+	name := h.fnName[strings.LastIndex(h.fnName, ".")+1:]
+	// method values are suffixed with -fm
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// Close stops the workers of a handler made with WithAsync once they've run the jobs
+// already queued, and later jobs fail with ErrAsyncClosed. It does nothing for other
+// handlers.
+func (h *Handler) Close() error {
+	if h.async != nil {
+		h.async.close()
+	}
+
+	return nil
+}
+
+// before runs a middleware chain, stopping at the first error, and returns the
+// request for the rest of the chain to see
+func (h *Handler) before(r *http.Request, middlewares []Middleware) (*http.Request, error) {
+	for _, mw := range middlewares {
+		rm, ok := mw.(RequestMiddleware)
+		if !ok {
+			err := mw.Before(r, h)
+			if err != nil {
+				return nil, err
 			}
+			continue
+		}
 
-			h.errorHandler(w, err)
-			return
+		next, err := rm.BeforeRequest(r, h)
+		if err != nil {
+			return nil, err
+		}
+
+		if next != nil {
+			r = next
+		}
+	}
+
+	return r, nil
+}
+
+// writeMiddlewareError responds with the status of a MiddlewareError, or a 500 for
+// any other error
+func (h *Handler) writeMiddlewareError(w http.ResponseWriter, err error) {
+	writeMiddlewareError(w, h.errorHandler, err)
+}
+
+func writeMiddlewareError(w http.ResponseWriter, errorHandler ErrorHandler, err error) {
+	mwe, ok := err.(MiddlewareError)
+	if ok {
+		for k, v := range mwe.Header {
+			w.Header()[k] = v
 		}
+		w.WriteHeader(mwe.StatusCode)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
 	}
 
-	canonicalMime, _, err := mime.ParseMediaType(r.Header.Get(MimeTypeHeader))
+	errorHandler(w, err)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveMiddleware(w, r, h, h.middlewares, h.serve)
+}
+
+// serveMiddleware runs serve wrapped by any Around middleware, then calls any After
+// middleware. serve is expected to run the Before chain itself.
+func serveMiddleware(w http.ResponseWriter, r *http.Request, h *Handler, middlewares []Middleware, serve http.HandlerFunc) {
+	var arounds []AroundMiddleware
+	var afters []AfterMiddleware
+	for _, mw := range middlewares {
+		if am, ok := mw.(AroundMiddleware); ok {
+			arounds = append(arounds, am)
+		}
+
+		if am, ok := mw.(AfterMiddleware); ok {
+			afters = append(afters, am)
+		}
+	}
+
+	if len(arounds) == 0 && len(afters) == 0 {
+		serve(w, r)
+		return
+	}
+
+	rw := newResponseWriter(w)
+	var next http.Handler = serve
+	for i := len(arounds) - 1; i >= 0; i-- {
+		next = arounds[i].Around(h, next)
+	}
+
+	// After middleware sees the request the function saw
+	served := &servedRequest{r: r}
+	next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), servedRequestKey{}, served)))
+	r = served.r
+
+	// unwind in reverse, like deferred calls
+	for i := len(afters) - 1; i >= 0; i-- {
+		afters[i].After(rw, r, h)
+	}
+}
+
+// servedRequest records the request the Before chain passed on, for After middleware
+type servedRequest struct {
+	r *http.Request
+}
+
+type servedRequestKey struct{}
+
+func recordServed(r *http.Request) {
+	if served, ok := r.Context().Value(servedRequestKey{}).(*servedRequest); ok {
+		served.r = r
+	}
+}
+
+// serve runs the Before middleware chain and hands the request to a codec
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) {
+	r, err := h.before(r, h.middlewares)
+	if err == nil {
+		err = h.authorize(r)
+	}
+	if err != nil {
+		h.writeMiddlewareError(w, err)
+		return
+	}
+
+	recordServed(r)
+
+	contentType := r.Header.Get(MimeTypeHeader)
+	if contentType == "" {
+		// requests without a body, such as an EventSource connecting, can pick a codec with Accept instead
+		contentType = h.acceptedMime(r.Header.Get("Accept"))
+	}
+
+	canonicalMime, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		h.errorHandler(w, err)
@@ -146,13 +319,22 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if vc, ok := codec.(valueCodec); ok && h.async != nil {
+		h.async.submit(h, vc, w, r)
+		return
+	}
+
+	codec.HandleRequest(h.codecRequestArgs(w, r))
+}
+
+func (h *Handler) codecRequestArgs(w http.ResponseWriter, r *http.Request) *CodecRequestArgs {
 	var header = make(Header)
 	for k := range r.Header {
 		hVal := r.Header.Get(k)
 		header[http.CanonicalHeaderKey(k)] = hVal
 	}
 
-	codec.HandleRequest(&CodecRequestArgs{
+	return &CodecRequestArgs{
 		ResponseWriter: w,
 		Request:        r,
 		Header:         header,
@@ -162,7 +344,82 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		InputArgCount:  h.inputArgCount,
 		OutputArgCount: h.outputArgCount,
 		MaxSizeBytes:   h.maxSizeBytes,
-	})
+		args:           h.args,
+		cookieSigner:   h.cookieSigner,
+	}
+}
+
+// acceptedMime returns the first mime type listed in an Accept header that one of
+// our codecs handles
+func (h *Handler) acceptedMime(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		if _, ok := h.mimeToCodec[mediaType]; ok {
+			return mediaType
+		}
+	}
+
+	return ""
+}
+
+// the steps of invoke that can fail
+const (
+	invokeMiddleware = iota
+	invokeDecode
+	invokeCall
+	invokePanic
+)
+
+// an invokeError records which step of invoke failed
+type invokeError struct {
+	step int
+	err  error
+}
+
+func (ie *invokeError) Error() string {
+	return ie.err.Error()
+}
+
+// invoke runs middlewares and the function behind h for a request whose body is a
+// JSON encoded arg, returning the function's output value. It's used by transports
+// that don't write a response per call, such as JSON-RPC.
+func (h *Handler) invoke(r *http.Request, middlewares []Middleware) (result interface{}, ie *invokeError) {
+	defer func() {
+		if p := recover(); p != nil {
+			result = nil
+			ie = &invokeError{step: invokePanic, err: fmt.Errorf("autoroute: %v", p)}
+		}
+	}()
+
+	r, err := h.before(r, middlewares)
+	if err == nil {
+		err = h.authorize(r)
+	}
+	if err != nil {
+		return nil, &invokeError{step: invokeMiddleware, err: err}
+	}
+
+	// there's no response per call, so anything the function writes is discarded
+	cra := h.codecRequestArgs(newResponseRecorder(), r)
+	callArgs, err := buildCallArgs(cra, jsonCodec{}.decode)
+	if err != nil {
+		if ie, ok := err.(*injectError); ok {
+			return nil, &invokeError{step: invokeMiddleware, err: ie.err}
+		}
+
+		return nil, &invokeError{step: invokeDecode, err: err}
+	}
+
+	result, err = outputResult(h.reflectFn.Call(callArgs))
+	if err != nil {
+		return nil, &invokeError{step: invokeCall, err: err}
+	}
+
+	return result, nil
 }
 
 func newReflectType(t reflect.Type) reflect.Value {
@@ -171,5 +428,8 @@ func newReflectType(t reflect.Type) reflect.Value {
 		t = t.Elem()
 	}
 
-	return reflect.New(t)
+	object := reflect.New(t)
+	applyDefaults(object)
+
+	return object
 }
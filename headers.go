@@ -0,0 +1,111 @@
+package autoroute
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var httpHeaderType = reflect.TypeOf(http.Header{})
+var timeType = reflect.TypeOf(time.Time{})
+
+// isBoundStruct reports whether t is a struct, or a pointer to one, with fields
+// tagged `header:"Name"` or `cookie:"name"`. Functions can take one in place of,
+// or as well as, a body arg.
+func isBoundStruct(t reflect.Type) bool {
+	return hasTaggedField(t, "header") || hasTaggedField(t, "cookie")
+}
+
+func hasTaggedField(t reflect.Type, key string) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Tag.Get(key); name != "" && name != "-" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bindRequestFields decodes headers and cookies into a new value of t, a bound struct.
+// Header fields take the first value of their header, except slices which take every
+// value, each of which can also be a comma separated list. Fields are converted like
+// default tags, and time.Time fields also accept HTTP dates. Default and validate tags
+// are applied.
+func bindRequestFields(t reflect.Type, header http.Header, cookies Cookies) (reflect.Value, error) {
+	object := newReflectType(t)
+	v := object.Elem()
+
+	var fields []FieldError
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		var name string
+		var values []string
+		if name = sf.Tag.Get("header"); name != "" && name != "-" {
+			values = header.Values(name)
+		} else if name = sf.Tag.Get("cookie"); name != "" && name != "-" {
+			if value, ok := cookies[name]; ok {
+				values = []string{value}
+			}
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		raw := values[0]
+		if sf.Type.Kind() == reflect.Slice && sf.Type.Elem().Kind() != reflect.Uint8 {
+			raw = strings.Join(values, ",")
+		}
+
+		err := setHeaderField(v.Field(i), raw)
+		if err != nil {
+			fields = append(fields, FieldError{Field: name, Message: fmt.Sprintf("must be a valid %s", sf.Type)})
+		}
+	}
+
+	if len(fields) > 0 {
+		return reflect.Value{}, &ValidationError{Fields: fields}
+	}
+
+	err := validateInput(object)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return object, nil
+	}
+
+	return v, nil
+}
+
+func setHeaderField(v reflect.Value, raw string) error {
+	t := v.Type()
+	if t == timeType || (t.Kind() == reflect.Ptr && t.Elem() == timeType) {
+		if when, err := http.ParseTime(raw); err == nil {
+			tv := reflect.ValueOf(when)
+			if t.Kind() == reflect.Ptr {
+				tv = reflect.New(timeType)
+				tv.Elem().Set(reflect.ValueOf(when))
+			}
+			v.Set(tv)
+			return nil
+		}
+	}
+
+	return setFromString(v, raw)
+}
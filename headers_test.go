@@ -0,0 +1,69 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type TestHeaders struct {
+	RequestID string        `header:"X-Request-Id" validate:"required"`
+	Limit     int           `header:"X-Limit" default:"10"`
+	Timeout   time.Duration `header:"X-Timeout"`
+	Since     time.Time     `header:"If-Modified-Since"`
+	Forwarded []string      `header:"X-Forwarded-For"`
+}
+
+func TestHeaderBinding(t *testing.T) {
+	t.Parallel()
+
+	fn := func(th *TestHeaders, hdr http.Header) map[string]interface{} {
+		return map[string]interface{}{
+			"id":        th.RequestID,
+			"limit":     th.Limit,
+			"timeout":   th.Timeout.String(),
+			"since":     th.Since,
+			"forwarded": th.Forwarded,
+			"accept":    hdr.Values("Accept"),
+		}
+	}
+
+	h, err := NewHandler(fn, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-Timeout", "2s")
+	req.Header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+	req.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	req.Header.Add("X-Forwarded-For", "3.3.3.3")
+	req.Header.Add("Accept", "text/plain")
+	req.Header.Add("Accept", "application/json")
+	h.ServeHTTP(w, req)
+
+	diffJSON(t, `{"accept":["text/plain","application/json"],"forwarded":["1.1.1.1","2.2.2.2","3.3.3.3"],"id":"abc","limit":10,"since":"2015-10-21T07:28:00Z","timeout":"2s"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.Header.Set("X-Limit", "lots")
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422, got %d", w.Code)
+	}
+
+	diffJSON(t, `{"error":"autoroute: invalid input: X-Limit must be a valid int","fields":[{"field":"X-Limit","message":"must be a valid int"}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	h.ServeHTTP(w, req)
+
+	diffJSON(t, `{"error":"autoroute: invalid input: X-Request-Id is required","fields":[{"field":"X-Request-Id","message":"is required"}]}`, w.Body.String())
+}
@@ -0,0 +1,184 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	bigNum, _ := new(big.Int).SetString("18446744073709551616", 10)
+
+	// examples from RFC 8949 appendix A
+	var cases = []struct {
+		name          string
+		value         interface{}
+		deterministic bool
+		hex           string
+	}{
+		{"zero", 0, false, "00"},
+		{"small", 23, false, "17"},
+		{"one byte", 24, false, "1818"},
+		{"two bytes", 1000, false, "1903e8"},
+		{"eight bytes", uint64(1000000000000), false, "1b000000e8d4a51000"},
+		{"negative", -1000, false, "3903e7"},
+		{"bignum", bigNum, false, "c249010000000000000000"},
+		{"bignum fits", big.NewInt(-10), false, "29"},
+		{"float64", 1.1, false, "fb3ff199999999999a"},
+		{"float16", 1.5, true, "f93e00"},
+		{"float32", 100000.0, true, "fa47c35000"},
+		{"half subnormal", 5.960464477539063e-8, true, "f90001"},
+		{"infinity", math.Inf(1), true, "f97c00"},
+		{"nan", math.NaN(), true, "f97e00"},
+		{"bool", true, false, "f5"},
+		{"nil", nil, false, "f6"},
+		{"bytes", []byte{1, 2, 3, 4}, false, "4401020304"},
+		{"text", "IETF", false, "6449455446"},
+		{"array", []int{1, 2, 3}, false, "83010203"},
+		{"time", time.Unix(1363896240, 0), false, "c11a514b67b0"},
+		{"sorted map", map[string]int{"b": 2, "a": 1, "aa": 3}, true, "a3616101616202626161" + "03"},
+	}
+
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.Deterministic = tt.deterministic
+
+			err := enc.Encode(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := hex.EncodeToString(buf.Bytes()); got != tt.hex {
+				t.Fatalf("expected %s, got %s", tt.hex, got)
+			}
+		})
+	}
+}
+
+type testItem struct {
+	Name     string    `cbor:"name"`
+	Count    int       `json:"count"`
+	Tags     []string  `cbor:"tags,omitempty"`
+	Created  time.Time `cbor:"created"`
+	Balance  *big.Int  `cbor:"balance"`
+	Optional *string   `cbor:"optional"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	balance, _ := new(big.Int).SetString("-340282366920938463463374607431768211456", 10)
+	in := testItem{
+		Name:    "sensor",
+		Count:   -42,
+		Tags:    []string{"a", "b"},
+		Created: time.Unix(1600000000, 500000000).UTC(),
+		Balance: balance,
+	}
+
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out testItem
+	err = Unmarshal(b, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !out.Created.Equal(in.Created) {
+		t.Fatalf("time did not round trip, got %s", out.Created)
+	}
+	out.Created = in.Created
+
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+}
+
+func TestDecodeGeneric(t *testing.T) {
+	t.Parallel()
+
+	// {_ "a": 1, "b": [_ 2, 3]} using indefinite lengths
+	var v interface{}
+	err := Unmarshal(mustHex(t, "bf61610161629f0203ffff"), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"a": uint64(1),
+		"b": []interface{}{uint64(2), uint64(3)},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, v)
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	t.Parallel()
+
+	var cases = []struct {
+		name     string
+		hex      string
+		expected error
+	}{
+		// [[[[1]]]]
+		{"nested", "8181818101", ErrMaxNestedLevels},
+		// an array claiming 2^32 elements
+		{"huge array", "9b0000000100000000", ErrMaxItems},
+		{"indefinite array", "9f0102030405ff", ErrMaxItems},
+	}
+
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoder(bytes.NewReader(mustHex(t, tt.hex)))
+			dec.MaxNestedLevels = 3
+			dec.MaxItems = 4
+
+			var v interface{}
+			err := dec.Decode(&v)
+			if err != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	b, err := Marshal(map[string]interface{}{"name": "x", "extra": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields = true
+
+	var out testItem
+	err = dec.Decode(&out)
+	if err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
@@ -0,0 +1,751 @@
+package cbor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxNestedLevels is the default limit on how deeply arrays, maps and
+	// tags may be nested
+	DefaultMaxNestedLevels = 32
+	// DefaultMaxItems is the default limit on the number of elements in a single
+	// array or pairs in a single map
+	DefaultMaxItems = 131072
+)
+
+var (
+	ErrMaxNestedLevels = errors.New("cbor: exceeded max nested levels")
+	ErrMaxItems        = errors.New("cbor: exceeded max number of items in an array or map")
+)
+
+// nodes produced by parse, before they're assigned into a Go value
+type (
+	negInt struct {
+		// the encoded argument, the value itself is -1 - n
+		n uint64
+	}
+
+	pair struct {
+		key, value interface{}
+	}
+
+	mapNode []pair
+
+	tagNode struct {
+		num     uint64
+		content interface{}
+	}
+)
+
+// A Decoder reads and decodes CBOR data items from an input stream
+type Decoder struct {
+	r *bufio.Reader
+
+	// MaxNestedLevels limits how deeply arrays, maps and tags may be nested
+	MaxNestedLevels int
+	// MaxItems limits the number of elements in a single array or pairs in a single map
+	MaxItems int
+	// DisallowUnknownFields causes an error when decoding a map key into a struct
+	// that has no matching field
+	DisallowUnknownFields bool
+}
+
+// NewDecoder returns a new decoder that reads from r using the default limits
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:               bufio.NewReader(r),
+		MaxNestedLevels: DefaultMaxNestedLevels,
+		MaxItems:        DefaultMaxItems,
+	}
+}
+
+// Unmarshal decodes the CBOR data item in data into the value pointed to by v
+func Unmarshal(data []byte, v interface{}) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Decode reads the next CBOR data item from the stream and stores it in the value
+// pointed to by v. It returns io.EOF if the stream is empty.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("cbor: Decode requires a non-nil pointer")
+	}
+
+	_, err := d.r.Peek(1)
+	if err != nil {
+		return err
+	}
+
+	node, err := d.parse(0)
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return d.assign(node, rv.Elem())
+}
+
+// readArg reads the argument of a data item given its additional information
+func (d *Decoder) readArg(ai byte) (n uint64, indefinite bool, err error) {
+	switch {
+	case ai < 24:
+		return uint64(ai), false, nil
+	case ai <= 27:
+		size := 1 << (ai - 24)
+		for i := 0; i < size; i++ {
+			b, err := d.r.ReadByte()
+			if err != nil {
+				return 0, false, err
+			}
+			n = n<<8 | uint64(b)
+		}
+		return n, false, nil
+	case ai == 31:
+		return 0, true, nil
+	}
+
+	return 0, false, fmt.Errorf("cbor: invalid additional information %d", ai)
+}
+
+func (d *Decoder) parse(depth int) (interface{}, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if b == breakCode {
+		return nil, errBreak
+	}
+
+	major, ai := b&majorMask, b&0x1f
+	if major == majorSimple {
+		return d.parseSimple(ai)
+	}
+
+	n, indefinite, err := d.readArg(ai)
+	if err != nil {
+		return nil, err
+	}
+
+	if indefinite && (major == majorUint || major == majorNegInt || major == majorTag) {
+		return nil, fmt.Errorf("cbor: indefinite length %s", kindName(major))
+	}
+
+	switch major {
+	case majorUint:
+		return n, nil
+	case majorNegInt:
+		return negInt{n: n}, nil
+	case majorBytes, majorText:
+		s, err := d.parseString(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+
+		if major == majorText {
+			if !utf8.Valid(s) {
+				return nil, errors.New("cbor: invalid UTF-8 in text string")
+			}
+			return string(s), nil
+		}
+
+		return s, nil
+	case majorArray:
+		if depth >= d.MaxNestedLevels {
+			return nil, ErrMaxNestedLevels
+		}
+
+		if !indefinite && n > uint64(d.MaxItems) {
+			return nil, ErrMaxItems
+		}
+
+		arr := make([]interface{}, 0, capHint(n))
+		for i := uint64(0); indefinite || i < n; i++ {
+			item, err := d.parse(depth + 1)
+			if indefinite && err == errBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if len(arr) >= d.MaxItems {
+				return nil, ErrMaxItems
+			}
+			arr = append(arr, item)
+		}
+
+		return arr, nil
+	case majorMap:
+		if depth >= d.MaxNestedLevels {
+			return nil, ErrMaxNestedLevels
+		}
+
+		if !indefinite && n > uint64(d.MaxItems) {
+			return nil, ErrMaxItems
+		}
+
+		m := make(mapNode, 0, capHint(n))
+		for i := uint64(0); indefinite || i < n; i++ {
+			key, err := d.parse(depth + 1)
+			if indefinite && err == errBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := d.parse(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(m) >= d.MaxItems {
+				return nil, ErrMaxItems
+			}
+			m = append(m, pair{key: key, value: value})
+		}
+
+		return m, nil
+	case majorTag:
+		if depth >= d.MaxNestedLevels {
+			return nil, ErrMaxNestedLevels
+		}
+
+		content, err := d.parse(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return tagNode{num: n, content: content}, nil
+	}
+
+	return nil, fmt.Errorf("cbor: unknown major type %d", major>>5)
+}
+
+// capHint keeps a hostile length from allocating more than a little up front
+func capHint(n uint64) int {
+	if n > 1024 {
+		return 1024
+	}
+	return int(n)
+}
+
+func (d *Decoder) parseString(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: %s length %d too large", kindName(major), n)
+		}
+
+		// io.CopyN grows the buffer as data actually arrives, so a large
+		// declared length can't force a large allocation on its own
+		var buf bytes.Buffer
+		_, err := io.CopyN(&buf, d.r, int64(n))
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	var buf bytes.Buffer
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b == breakCode {
+			return buf.Bytes(), nil
+		}
+
+		if b&majorMask != major {
+			return nil, fmt.Errorf("cbor: invalid chunk in indefinite length %s", kindName(major))
+		}
+
+		cn, chunkIndefinite, err := d.readArg(b & 0x1f)
+		if err != nil {
+			return nil, err
+		}
+		if chunkIndefinite {
+			return nil, fmt.Errorf("cbor: nested indefinite length %s", kindName(major))
+		}
+
+		chunk, err := d.parseString(major, cn, false)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(chunk)
+	}
+}
+
+func (d *Decoder) parseSimple(ai byte) (interface{}, error) {
+	switch ai {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		// null and undefined
+		return nil, nil
+	case 25, 26, 27:
+		n, _, err := d.readArg(ai)
+		if err != nil {
+			return nil, err
+		}
+
+		switch ai {
+		case 25:
+			return float16ToFloat64(uint16(n)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), nil
+		}
+		return math.Float64frombits(n), nil
+	}
+
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", ai)
+}
+
+func nodeKind(node interface{}) string {
+	switch node.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case uint64:
+		return kindName(majorUint)
+	case negInt:
+		return kindName(majorNegInt)
+	case float64:
+		return "float"
+	case []byte:
+		return kindName(majorBytes)
+	case string:
+		return kindName(majorText)
+	case []interface{}:
+		return kindName(majorArray)
+	case mapNode:
+		return kindName(majorMap)
+	case tagNode:
+		return kindName(majorTag)
+	}
+
+	return "unknown"
+}
+
+func typeError(node interface{}, t reflect.Type) error {
+	return fmt.Errorf("cbor: cannot unmarshal %s into Go value of type %s", nodeKind(node), t)
+}
+
+func (d *Decoder) assign(node interface{}, v reflect.Value) error {
+	switch v.Type() {
+	case timeType:
+		if node == nil {
+			return nil
+		}
+
+		t, err := nodeTime(node)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case bigIntType:
+		if node == nil {
+			return nil
+		}
+
+		bi, err := nodeBigInt(node)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*bi))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if node == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.assign(node, v.Elem())
+	case reflect.Interface:
+		if node == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+
+		if v.NumMethod() != 0 {
+			return typeError(node, v.Type())
+		}
+
+		g, err := generic(node)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(g))
+		return nil
+	}
+
+	if tn, ok := node.(tagNode); ok {
+		// we don't know this tag's semantics for this type, so use its content as is
+		return d.assign(tn.content, v)
+	}
+
+	if node == nil {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		// like encoding/json, null leaves anything else untouched
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := node.(bool)
+		if !ok {
+			return typeError(node, v.Type())
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch n := node.(type) {
+		case uint64:
+			if n > math.MaxInt64 {
+				return typeError(node, v.Type())
+			}
+			i = int64(n)
+		case negInt:
+			if n.n > math.MaxInt64 {
+				return typeError(node, v.Type())
+			}
+			i = -1 - int64(n.n)
+		default:
+			return typeError(node, v.Type())
+		}
+
+		if v.OverflowInt(i) {
+			return fmt.Errorf("cbor: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := node.(uint64)
+		if !ok {
+			return typeError(node, v.Type())
+		}
+
+		if v.OverflowUint(n) {
+			return fmt.Errorf("cbor: %d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch n := node.(type) {
+		case float64:
+			f = n
+		case uint64:
+			f = float64(n)
+		case negInt:
+			f = -1 - float64(n.n)
+		default:
+			return typeError(node, v.Type())
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		s, ok := node.(string)
+		if !ok {
+			return typeError(node, v.Type())
+		}
+		v.SetString(s)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, ok := node.([]byte)
+			if !ok {
+				return typeError(node, v.Type())
+			}
+			v.SetBytes(b)
+			return nil
+		}
+
+		arr, ok := node.([]interface{})
+		if !ok {
+			return typeError(node, v.Type())
+		}
+
+		sl := reflect.MakeSlice(v.Type(), len(arr), len(arr))
+		for i, item := range arr {
+			err := d.assign(item, sl.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(sl)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, ok := node.([]byte)
+			if !ok {
+				return typeError(node, v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+
+		arr, ok := node.([]interface{})
+		if !ok {
+			return typeError(node, v.Type())
+		}
+
+		for i := 0; i < v.Len() && i < len(arr); i++ {
+			err := d.assign(arr[i], v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := node.(mapNode)
+		if !ok {
+			return typeError(node, v.Type())
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		for _, p := range m {
+			key := reflect.New(v.Type().Key()).Elem()
+			err := d.assign(p.key, key)
+			if err != nil {
+				return err
+			}
+
+			value := reflect.New(v.Type().Elem()).Elem()
+			err = d.assign(p.value, value)
+			if err != nil {
+				return err
+			}
+
+			v.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		m, ok := node.(mapNode)
+		if !ok {
+			return typeError(node, v.Type())
+		}
+
+		return d.assignStruct(m, v)
+	default:
+		return typeError(node, v.Type())
+	}
+
+	return nil
+}
+
+func (d *Decoder) assignStruct(m mapNode, v reflect.Value) error {
+	fields := cachedFields(v.Type())
+	for _, p := range m {
+		name, ok := p.key.(string)
+		if !ok {
+			return fmt.Errorf("cbor: cannot use %s as a field name of %s", nodeKind(p.key), v.Type())
+		}
+
+		f := findField(fields, name)
+		if f == nil {
+			if d.DisallowUnknownFields {
+				return fmt.Errorf("cbor: unknown field %q", name)
+			}
+			continue
+		}
+
+		fv := v
+		for i, x := range f.index {
+			if i > 0 && fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			fv = fv.Field(x)
+		}
+
+		err := d.assign(p.value, fv)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findField prefers an exact match, falling back to a case-insensitive one like encoding/json
+func findField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+
+	return nil
+}
+
+func nodeTime(node interface{}) (time.Time, error) {
+	if tn, ok := node.(tagNode); ok {
+		if tn.num != tagDateTimeString && tn.num != tagEpochDateTime {
+			return time.Time{}, fmt.Errorf("cbor: cannot unmarshal tag %d into time.Time", tn.num)
+		}
+		node = tn.content
+	}
+
+	switch n := node.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, n)
+	case uint64:
+		if n > math.MaxInt64 {
+			return time.Time{}, errors.New("cbor: epoch time out of range")
+		}
+		return time.Unix(int64(n), 0), nil
+	case negInt:
+		if n.n > math.MaxInt64 {
+			return time.Time{}, errors.New("cbor: epoch time out of range")
+		}
+		return time.Unix(-1-int64(n.n), 0), nil
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return time.Time{}, errors.New("cbor: epoch time out of range")
+		}
+		secs, frac := math.Modf(n)
+		return time.Unix(int64(secs), int64(frac*1e9)), nil
+	}
+
+	return time.Time{}, typeError(node, timeType)
+}
+
+func nodeBigInt(node interface{}) (*big.Int, error) {
+	switch n := node.(type) {
+	case uint64:
+		return new(big.Int).SetUint64(n), nil
+	case negInt:
+		bi := new(big.Int).SetUint64(n.n)
+		return bi.Neg(bi.Add(bi, big.NewInt(1))), nil
+	case tagNode:
+		b, ok := n.content.([]byte)
+		if !ok || (n.num != tagPosBignum && n.num != tagNegBignum) {
+			break
+		}
+
+		bi := new(big.Int).SetBytes(b)
+		if n.num == tagNegBignum {
+			bi.Neg(bi.Add(bi, big.NewInt(1)))
+		}
+		return bi, nil
+	}
+
+	return nil, typeError(node, bigIntType)
+}
+
+// generic turns a node into the value it takes when decoded into an interface{}
+func generic(node interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case negInt:
+		if n.n > math.MaxInt64 {
+			return nodeBigInt(n)
+		}
+		return -1 - int64(n.n), nil
+	case []interface{}:
+		arr := make([]interface{}, len(n))
+		for i, item := range n {
+			g, err := generic(item)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = g
+		}
+		return arr, nil
+	case mapNode:
+		return genericMap(n)
+	case tagNode:
+		switch n.num {
+		case tagDateTimeString, tagEpochDateTime:
+			return nodeTime(n)
+		case tagPosBignum, tagNegBignum:
+			return nodeBigInt(n)
+		}
+		return generic(n.content)
+	}
+
+	return node, nil
+}
+
+// genericMap returns a map[string]interface{} when every key is a text string,
+// and a map[interface{}]interface{} otherwise
+func genericMap(m mapNode) (interface{}, error) {
+	allText := true
+	for _, p := range m {
+		if _, ok := p.key.(string); !ok {
+			allText = false
+			break
+		}
+	}
+
+	if allText {
+		out := make(map[string]interface{}, len(m))
+		for _, p := range m {
+			g, err := generic(p.value)
+			if err != nil {
+				return nil, err
+			}
+			out[p.key.(string)] = g
+		}
+		return out, nil
+	}
+
+	out := make(map[interface{}]interface{}, len(m))
+	for _, p := range m {
+		key, err := generic(p.key)
+		if err != nil {
+			return nil, err
+		}
+
+		switch k := key.(type) {
+		case []byte:
+			key = string(k)
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}:
+			return nil, fmt.Errorf("cbor: cannot use %s as a map key", nodeKind(p.key))
+		}
+
+		value, err := generic(p.value)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+
+	return out, nil
+}
@@ -0,0 +1,567 @@
+// Package cbor implements the subset of RFC 8949 Concise Binary Object Representation
+// autoroute needs to serve application/cbor requests.
+package cbor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// major types, already shifted into the high three bits of the initial byte
+const (
+	majorUint   byte = 0 << 5
+	majorNegInt byte = 1 << 5
+	majorBytes  byte = 2 << 5
+	majorText   byte = 3 << 5
+	majorArray  byte = 4 << 5
+	majorMap    byte = 5 << 5
+	majorTag    byte = 6 << 5
+	majorSimple byte = 7 << 5
+	majorMask   byte = 7 << 5
+)
+
+const (
+	tagDateTimeString = 0
+	tagEpochDateTime  = 1
+	tagPosBignum      = 2
+	tagNegBignum      = 3
+)
+
+const (
+	simpleFalse     byte = 0xf4
+	simpleTrue      byte = 0xf5
+	simpleNull      byte = 0xf6
+	simpleUndefined byte = 0xf7
+	simpleFloat16   byte = 0xf9
+	simpleFloat32   byte = 0xfa
+	simpleFloat64   byte = 0xfb
+	breakCode       byte = 0xff
+)
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	bigIntType = reflect.TypeOf(big.Int{})
+)
+
+// An UnsupportedTypeError is returned when trying to encode a value of a type
+// CBOR has no representation for
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "cbor: unsupported type " + e.Type.String()
+}
+
+// An Encoder writes CBOR data items to an output stream
+type Encoder struct {
+	w io.Writer
+
+	// Deterministic enables the core deterministic encoding requirements of
+	// RFC 8949 section 4.2.1: map keys are sorted by their encoded bytes and floats
+	// use the shortest form that preserves their value.
+	Deterministic bool
+}
+
+// NewEncoder returns a new encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the CBOR encoding of v to the stream
+func (e *Encoder) Encode(v interface{}) error {
+	var buf bytes.Buffer
+	err := e.encode(&buf, reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// Marshal returns the CBOR encoding of v
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		buf.WriteByte(byte(n >> 8))
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		for shift := 24; shift >= 0; shift -= 8 {
+			buf.WriteByte(byte(n >> uint(shift)))
+		}
+	default:
+		buf.WriteByte(major | 27)
+		for shift := 56; shift >= 0; shift -= 8 {
+			buf.WriteByte(byte(n >> uint(shift)))
+		}
+	}
+}
+
+func (e *Encoder) encode(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteByte(simpleNull)
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		return e.encodeTime(buf, v.Interface().(time.Time))
+	case bigIntType:
+		bi := v.Interface().(big.Int)
+		return e.encodeBigInt(buf, &bi)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte(simpleNull)
+			return nil
+		}
+
+		return e.encode(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(simpleTrue)
+		} else {
+			buf.WriteByte(simpleFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i < 0 {
+			writeHead(buf, majorNegInt, uint64(-(i + 1)))
+		} else {
+			writeHead(buf, majorUint, uint64(i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeHead(buf, majorUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.encodeFloat(buf, v.Float(), v.Kind() == reflect.Float32)
+	case reflect.String:
+		writeHead(buf, majorText, uint64(v.Len()))
+		buf.WriteString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteByte(simpleNull)
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeHead(buf, majorBytes, uint64(v.Len()))
+			buf.Write(v.Bytes())
+			return nil
+		}
+
+		return e.encodeArray(buf, v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeHead(buf, majorBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				buf.WriteByte(byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+
+		return e.encodeArray(buf, v)
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(simpleNull)
+			return nil
+		}
+
+		return e.encodeMap(buf, v)
+	case reflect.Struct:
+		return e.encodeStruct(buf, v)
+	default:
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+
+	return nil
+}
+
+func (e *Encoder) encodeArray(buf *bytes.Buffer, v reflect.Value) error {
+	writeHead(buf, majorArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		err := e.encode(buf, v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type encodedPair struct {
+	key, value []byte
+}
+
+// writePairs writes an already encoded map, sorting it first in deterministic mode
+func (e *Encoder) writePairs(buf *bytes.Buffer, pairs []encodedPair) {
+	if e.Deterministic {
+		sort.Slice(pairs, func(i, j int) bool {
+			return bytes.Compare(pairs[i].key, pairs[j].key) < 0
+		})
+	}
+
+	writeHead(buf, majorMap, uint64(len(pairs)))
+	for _, p := range pairs {
+		buf.Write(p.key)
+		buf.Write(p.value)
+	}
+}
+
+func (e *Encoder) encodeMap(buf *bytes.Buffer, v reflect.Value) error {
+	pairs := make([]encodedPair, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		var kb, vb bytes.Buffer
+		err := e.encode(&kb, iter.Key())
+		if err != nil {
+			return err
+		}
+
+		err = e.encode(&vb, iter.Value())
+		if err != nil {
+			return err
+		}
+
+		pairs = append(pairs, encodedPair{key: kb.Bytes(), value: vb.Bytes()})
+	}
+
+	e.writePairs(buf, pairs)
+	return nil
+}
+
+func (e *Encoder) encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	fields := cachedFields(v.Type())
+	pairs := make([]encodedPair, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue
+		}
+
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		var kb, vb bytes.Buffer
+		writeHead(&kb, majorText, uint64(len(f.name)))
+		kb.WriteString(f.name)
+
+		err := e.encode(&vb, fv)
+		if err != nil {
+			return err
+		}
+
+		pairs = append(pairs, encodedPair{key: kb.Bytes(), value: vb.Bytes()})
+	}
+
+	e.writePairs(buf, pairs)
+	return nil
+}
+
+// encodeTime writes t as an epoch based date/time (tag 1), using an integer when
+// t has no sub-second component
+func (e *Encoder) encodeTime(buf *bytes.Buffer, t time.Time) error {
+	writeHead(buf, majorTag, tagEpochDateTime)
+	if t.Nanosecond() == 0 {
+		return e.encode(buf, reflect.ValueOf(t.Unix()))
+	}
+
+	secs := float64(t.Unix()) + float64(t.Nanosecond())/1e9
+	e.encodeFloat(buf, secs, false)
+	return nil
+}
+
+// encodeBigInt writes bi as a plain integer when it fits, and as a bignum
+// (tags 2 and 3) otherwise, as required by preferred serialization
+func (e *Encoder) encodeBigInt(buf *bytes.Buffer, bi *big.Int) error {
+	if bi.Sign() >= 0 {
+		if bi.IsUint64() {
+			writeHead(buf, majorUint, bi.Uint64())
+			return nil
+		}
+
+		writeHead(buf, majorTag, tagPosBignum)
+		b := bi.Bytes()
+		writeHead(buf, majorBytes, uint64(len(b)))
+		buf.Write(b)
+		return nil
+	}
+
+	// negative integers are encoded as -1 - n
+	n := new(big.Int).Neg(bi)
+	n.Sub(n, big.NewInt(1))
+	if n.IsUint64() {
+		writeHead(buf, majorNegInt, n.Uint64())
+		return nil
+	}
+
+	writeHead(buf, majorTag, tagNegBignum)
+	b := n.Bytes()
+	writeHead(buf, majorBytes, uint64(len(b)))
+	buf.Write(b)
+	return nil
+}
+
+func (e *Encoder) encodeFloat(buf *bytes.Buffer, f float64, is32 bool) {
+	if e.Deterministic {
+		if math.IsNaN(f) {
+			buf.Write([]byte{simpleFloat16, 0x7e, 0x00})
+			return
+		}
+
+		if h, ok := float16Bits(f); ok {
+			buf.Write([]byte{simpleFloat16, byte(h >> 8), byte(h)})
+			return
+		}
+
+		is32 = float64(float32(f)) == f
+	}
+
+	if is32 {
+		bits := math.Float32bits(float32(f))
+		buf.WriteByte(simpleFloat32)
+		for shift := 24; shift >= 0; shift -= 8 {
+			buf.WriteByte(byte(bits >> uint(shift)))
+		}
+		return
+	}
+
+	bits := math.Float64bits(f)
+	buf.WriteByte(simpleFloat64)
+	for shift := 56; shift >= 0; shift -= 8 {
+		buf.WriteByte(byte(bits >> uint(shift)))
+	}
+}
+
+// float16Bits converts f to IEEE 754 half precision, reporting whether that could
+// be done without losing any information
+func float16Bits(f float64) (uint16, bool) {
+	f32 := float32(f)
+	if float64(f32) != f && !math.IsInf(f, 0) {
+		return 0, false
+	}
+
+	bits := math.Float32bits(f32)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff:
+		if mant != 0 {
+			return 0x7e00, true
+		}
+		return sign | 0x7c00, true
+	case exp == 0 && mant == 0:
+		return sign, true
+	case exp == 0:
+		// float32 subnormals are far too small for a half
+		return 0, false
+	}
+
+	e := exp - 127
+	switch {
+	case e >= -14 && e <= 15:
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(e+15)<<10 | uint16(mant>>13), true
+	case e >= -24 && e < -14:
+		full := mant | 1<<23
+		shift := uint(-(e + 1))
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+
+	return 0, false
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant != 0 {
+			return math.NaN()
+		}
+		f = math.Inf(1)
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+
+	return f
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var (
+	fieldCacheMu sync.Mutex
+	fieldCache   = make(map[reflect.Type][]field)
+)
+
+// cachedFields lists the encodable fields of a struct type, flattening embedded
+// structs the way encoding/json does. Names come from a `cbor` tag, then a `json`
+// tag, then the field name itself.
+func cachedFields(t reflect.Type) []field {
+	fieldCacheMu.Lock()
+	defer fieldCacheMu.Unlock()
+
+	fields, ok := fieldCache[t]
+	if !ok {
+		fields = typeFields(t, nil)
+		fieldCache[t] = fields
+	}
+
+	return fields
+}
+
+func typeFields(t reflect.Type, index []int) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("cbor")
+		if !ok {
+			tag = sf.Tag.Get("json")
+		}
+
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, typeFields(ft, fieldIndex)...)
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, field{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+
+	return fields
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, but reports false instead of
+// panicking when it runs into a nil embedded pointer
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+var errBreak = errors.New("cbor: unexpected break")
+
+func kindName(major byte) string {
+	switch major {
+	case majorUint:
+		return "unsigned integer"
+	case majorNegInt:
+		return "negative integer"
+	case majorBytes:
+		return "byte string"
+	case majorText:
+		return "text string"
+	case majorArray:
+		return "array"
+	case majorMap:
+		return "map"
+	case majorTag:
+		return "tag"
+	}
+
+	return "simple value"
+}
@@ -0,0 +1,231 @@
+package htpasswd
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strconv"
+	"sync"
+)
+
+// bcrypt's base64 alphabet, which orders the characters differently to the standard one
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+var ErrBadBcryptHash = errors.New("malformed bcrypt hash")
+
+// the magic bcrypt encrypts with its expensive key schedule
+var bcryptMagic = []byte("OrpheanBeholderScryDoubt")
+
+// verifyBcrypt checks password against a $2a$, $2b$ or $2y$ hash
+func verifyBcrypt(hash, password string) (bool, error) {
+	cost, salt, err := parseBcrypt(hash)
+	if err != nil {
+		return false, err
+	}
+
+	sum := bcrypt([]byte(password), cost, salt)
+	return subtle.ConstantTimeCompare([]byte(bcryptEncoding.EncodeToString(sum)), []byte(hash[29:])) == 1, nil
+}
+
+// parseBcrypt checks the format of a hash, returning its cost and salt
+func parseBcrypt(hash string) (int, []byte, error) {
+	// $2b$10$ then a 22 character salt and a 31 character hash
+	if len(hash) != 60 || hash[0] != '$' || hash[1] != '2' || hash[3] != '$' || hash[6] != '$' {
+		return 0, nil, ErrBadBcryptHash
+	}
+
+	switch hash[2] {
+	case 'a', 'b', 'y':
+	default:
+		return 0, nil, ErrBadBcryptHash
+	}
+
+	cost, err := strconv.Atoi(hash[4:6])
+	if err != nil || cost < 4 || cost > 31 {
+		return 0, nil, ErrBadBcryptHash
+	}
+
+	salt, err := bcryptEncoding.DecodeString(hash[7:29])
+	if err != nil || len(salt) != 16 {
+		return 0, nil, ErrBadBcryptHash
+	}
+
+	sum, err := bcryptEncoding.DecodeString(hash[29:])
+	if err != nil || len(sum) != 23 {
+		return 0, nil, ErrBadBcryptHash
+	}
+
+	return cost, salt, nil
+}
+
+// bcrypt returns the 23 byte hash of password
+func bcrypt(password []byte, cost int, salt []byte) []byte {
+	// the key is NUL terminated, and only its first 72 bytes count
+	key := make([]byte, 0, len(password)+1)
+	key = append(key, password...)
+	key = append(key, 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+
+	c := newBlowfish()
+	c.expandKey(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		c.expandKey(key, nil)
+		c.expandKey(salt, nil)
+	}
+
+	words := make([]uint32, 6)
+	for i := range words {
+		words[i], _ = streamWord(bcryptMagic, i*4)
+	}
+
+	for i := 0; i < 64; i++ {
+		for j := 0; j < 6; j += 2 {
+			words[j], words[j+1] = c.encrypt(words[j], words[j+1])
+		}
+	}
+
+	out := make([]byte, 0, 24)
+	for _, w := range words {
+		out = append(out, byte(w>>24), byte(w>>16), byte(w>>8), byte(w))
+	}
+
+	return out[:23]
+}
+
+// blowfish is the state of the Blowfish cipher
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+var (
+	piOnce  sync.Once
+	piWords [18 + 4*256]uint32
+)
+
+// newBlowfish returns the initial state, the hex digits of pi's fractional part
+func newBlowfish() *blowfish {
+	piOnce.Do(computePiWords)
+
+	c := &blowfish{}
+	copy(c.p[:], piWords[:18])
+	for i := range c.s {
+		copy(c.s[i][:], piWords[18+i*256:18+(i+1)*256])
+	}
+
+	return c
+}
+
+// computePiWords works out the digits of pi with Machin's formula,
+// pi = 16 arctan(1/5) - 4 arctan(1/239), rather than carrying a table of them
+func computePiWords() {
+	const guard = 64
+	bits := uint(len(piWords)*32 + guard)
+
+	pi := new(big.Int).Mul(arctanInv(5, bits), big.NewInt(16))
+	pi.Sub(pi, new(big.Int).Mul(arctanInv(239, bits), big.NewInt(4)))
+
+	// drop the 3 and the guard bits, leaving the fraction
+	pi.Sub(pi, new(big.Int).Lsh(big.NewInt(3), bits))
+	pi.Rsh(pi, guard)
+
+	mask := big.NewInt(1<<32 - 1)
+	word := new(big.Int)
+	for i := range piWords {
+		shift := uint(len(piWords)-1-i) * 32
+		word.Rsh(pi, shift).And(word, mask)
+		piWords[i] = uint32(word.Uint64())
+	}
+}
+
+// arctanInv returns arctan(1/x) as a fixed point number with bits fractional bits
+func arctanInv(x int64, bits uint) *big.Int {
+	sum := new(big.Int)
+	term := new(big.Int).Lsh(big.NewInt(1), bits)
+	term.Quo(term, big.NewInt(x))
+
+	xx := big.NewInt(x * x)
+	q := new(big.Int)
+	for k := int64(0); term.Sign() != 0; k++ {
+		q.Quo(term, big.NewInt(2*k+1))
+		if k%2 == 0 {
+			sum.Add(sum, q)
+		} else {
+			sum.Sub(sum, q)
+		}
+		term.Quo(term, xx)
+	}
+
+	return sum
+}
+
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
+
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	for i := 0; i < 16; i += 2 {
+		l ^= c.p[i]
+		r ^= c.f(l)
+		r ^= c.p[i+1]
+		l ^= c.f(r)
+	}
+
+	l ^= c.p[16]
+	r ^= c.p[17]
+	return r, l
+}
+
+// streamWord reads 4 bytes of data as a big endian word from pos, wrapping around
+// to the start, and returns the position after them
+func streamWord(data []byte, pos int) (uint32, int) {
+	var w uint32
+	for i := 0; i < 4; i++ {
+		if pos >= len(data) {
+			pos = 0
+		}
+		w = w<<8 | uint32(data[pos])
+		pos++
+	}
+
+	return w, pos
+}
+
+// expandKey is bcrypt's ExpandKey, mixing key into the state and salt into each block
+// it encrypts. A nil salt gives Blowfish's standard key schedule.
+func (c *blowfish) expandKey(key, salt []byte) {
+	pos := 0
+	for i := range c.p {
+		var w uint32
+		w, pos = streamWord(key, pos)
+		c.p[i] ^= w
+	}
+
+	var l, r uint32
+	saltPos := 0
+	next := func() {
+		if salt != nil {
+			var w uint32
+			w, saltPos = streamWord(salt, saltPos)
+			l ^= w
+			w, saltPos = streamWord(salt, saltPos)
+			r ^= w
+		}
+		l, r = c.encrypt(l, r)
+	}
+
+	for i := 0; i < len(c.p); i += 2 {
+		next()
+		c.p[i], c.p[i+1] = l, r
+	}
+
+	for i := range c.s {
+		for j := 0; j < 256; j += 2 {
+			next()
+			c.s[i][j], c.s[i][j+1] = l, r
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package htpasswd reads htpasswd files and checks passwords against their hashes.
+// bcrypt ($2a$, $2b$, $2y$) and SHA-crypt ($5$, $6$) hashes are supported.
+package htpasswd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrUnsupportedHash = errors.New("unsupported hash format")
+
+// Parse reads "user:hash" lines, skipping blank lines and # comments. Every hash must be
+// well formed and in a supported format, so Verify won't fail on them.
+func Parse(r io.Reader) (map[string]string, error) {
+	users := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("htpasswd: line %d: expected user:hash", line)
+		}
+
+		user, hash := text[:i], text[i+1:]
+		if !Supported(hash) {
+			return nil, fmt.Errorf("htpasswd: line %d: %s: %w", line, user, ErrUnsupportedHash)
+		}
+
+		if err := check(hash); err != nil {
+			return nil, fmt.Errorf("htpasswd: line %d: %s: %w", line, user, err)
+		}
+
+		users[user] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Supported reports whether Verify can check hash
+func Supported(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$5$", "$6$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check validates the format of a supported hash without computing anything
+func check(hash string) error {
+	if strings.HasPrefix(hash, "$2") {
+		_, _, err := parseBcrypt(hash)
+		return err
+	}
+
+	_, err := parseSHACrypt(hash)
+	return err
+}
+
+// Verify checks password against hash, comparing in constant time
+func Verify(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return verifyBcrypt(hash, password)
+	case strings.HasPrefix(hash, "$5$"), strings.HasPrefix(hash, "$6$"):
+		return verifySHACrypt(hash, password)
+	}
+
+	return false, ErrUnsupportedHash
+}
@@ -0,0 +1,107 @@
+package htpasswd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPiWords(t *testing.T) {
+	t.Parallel()
+
+	c := newBlowfish()
+	for _, tc := range []struct {
+		name      string
+		got, want uint32
+	}{
+		{"P[0]", c.p[0], 0x243F6A88},
+		{"P[17]", c.p[17], 0x8979FB1B},
+		{"S0[0]", c.s[0][0], 0xD1310BA6},
+		{"S3[255]", c.s[3][255], 0x3AC372E6},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %08x, want %08x", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	var cases = []struct {
+		hash, password string
+	}{
+		{"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+		{"$2b$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm", "password"},
+		{"$5$rounds=5000$saltsalt$gOjOtoMpVhru2uyjeJSEc/JaLQWOXMNmlOnj6T4AtC.", "password"},
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+		{"$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/", "password"},
+	}
+
+	for _, tc := range cases {
+		ok, err := Verify(tc.hash, tc.password)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.hash, err)
+		}
+		if !ok {
+			t.Errorf("%s: expected %q to match", tc.hash, tc.password)
+		}
+
+		ok, err = Verify(tc.hash, tc.password+"x")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.hash, err)
+		}
+		if ok {
+			t.Errorf("%s: expected %q not to match", tc.hash, tc.password+"x")
+		}
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	t.Parallel()
+
+	for _, hash := range []string{"$2b$04$tooshort", "$2x$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm", "$5$a$b$c$d", "$5$salt$short", "$7$salt$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "plain"} {
+		_, err := Verify(hash, "password")
+		if err == nil {
+			t.Errorf("%s: expected an error", hash)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	users, err := Parse(strings.NewReader(`
+# admins
+ian:$2b$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm
+
+bob:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 2 || users["bob"] != "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5" {
+		t.Fatalf("unexpected users %v", users)
+	}
+
+	_, err = Parse(strings.NewReader("ian:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="))
+	if !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("expected ErrUnsupportedHash, got %v", err)
+	}
+
+	_, err = Parse(strings.NewReader("ian:$2b$04$abcdefghijklmnopqrstuu"))
+	if !errors.Is(err, ErrBadBcryptHash) {
+		t.Fatalf("expected ErrBadBcryptHash, got %v", err)
+	}
+
+	_, err = Parse(strings.NewReader("bob:$5$saltstring$tooshort"))
+	if !errors.Is(err, ErrBadSHACryptHash) {
+		t.Fatalf("expected ErrBadSHACryptHash, got %v", err)
+	}
+
+	_, err = Parse(strings.NewReader("no-colon"))
+	if err == nil {
+		t.Fatal("expected an error for a line without a hash")
+	}
+}
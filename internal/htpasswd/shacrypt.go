@@ -0,0 +1,218 @@
+package htpasswd
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+var ErrBadSHACryptHash = errors.New("malformed SHA-crypt hash")
+
+const (
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+)
+
+// shaCryptParams are the settings a SHA-crypt hash was made with
+type shaCryptParams struct {
+	newHash      func() hash.Hash
+	id           string
+	rounds       int
+	customRounds bool
+	salt         string
+}
+
+// verifySHACrypt checks password against a $5$ (SHA-256) or $6$ (SHA-512) hash
+func verifySHACrypt(hash, password string) (bool, error) {
+	p, err := parseSHACrypt(hash)
+	if err != nil {
+		return false, err
+	}
+
+	computed := shaCrypt(p.newHash, p.id, []byte(password), []byte(p.salt), p.rounds, p.customRounds)
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1, nil
+}
+
+// parseSHACrypt checks the format of a hash, returning its settings
+func parseSHACrypt(hash string) (*shaCryptParams, error) {
+	parts := strings.Split(hash, "$")
+	// "", "5", optionally "rounds=N", the salt and the hash
+	if len(parts) != 4 && len(parts) != 5 {
+		return nil, ErrBadSHACryptHash
+	}
+
+	p := &shaCryptParams{id: parts[1], rounds: shaCryptDefaultRounds}
+
+	// the encoded digest's length, 4 characters for every 3 bytes
+	var encodedLen int
+	switch p.id {
+	case "5":
+		p.newHash, encodedLen = sha256.New, 43
+	case "6":
+		p.newHash, encodedLen = sha512.New, 86
+	default:
+		return nil, ErrBadSHACryptHash
+	}
+
+	if len(parts) == 5 {
+		if !strings.HasPrefix(parts[2], "rounds=") {
+			return nil, ErrBadSHACryptHash
+		}
+
+		var err error
+		p.rounds, err = strconv.Atoi(strings.TrimPrefix(parts[2], "rounds="))
+		if err != nil {
+			return nil, ErrBadSHACryptHash
+		}
+		p.customRounds = true
+	}
+
+	p.salt = parts[len(parts)-2]
+	if len(p.salt) > 16 {
+		p.salt = p.salt[:16]
+	}
+
+	digest := parts[len(parts)-1]
+	if len(digest) != encodedLen || strings.Trim(digest, shaCryptAlphabet) != "" {
+		return nil, ErrBadSHACryptHash
+	}
+
+	return p, nil
+}
+
+// shaCrypt implements Ulrich Drepper's SHA-crypt, returning the full hash string
+func shaCrypt(newHash func() hash.Hash, id string, password, salt []byte, rounds int, customRounds bool) string {
+	if rounds < shaCryptMinRounds {
+		rounds = shaCryptMinRounds
+	} else if rounds > shaCryptMaxRounds {
+		rounds = shaCryptMaxRounds
+	}
+
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeatTo(b, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(password); i++ {
+		h.Write(password)
+	}
+	p := repeatTo(h.Sum(nil), len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatTo(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString("$" + id + "$")
+	if customRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.Write(salt)
+	out.WriteString("$")
+	shaCryptEncode(&out, c)
+
+	return out.String()
+}
+
+// repeatTo repeats b up to n bytes
+func repeatTo(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+
+	return out
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// shaCryptEncode writes a digest in SHA-crypt's own order, three bytes at a time taken
+// from a third of the way apart, least significant six bits first
+func shaCryptEncode(out *strings.Builder, c []byte) {
+	write := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for i := 0; i < n; i++ {
+			out.WriteByte(shaCryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+
+	if len(c) == sha256.Size {
+		for i := 0; i < 10; i++ {
+			x, y, z := c[i], c[i+10], c[i+20]
+			switch i % 3 {
+			case 0:
+				write(x, y, z, 4)
+			case 1:
+				write(z, x, y, 4)
+			case 2:
+				write(y, z, x, 4)
+			}
+		}
+		write(0, c[31], c[30], 3)
+		return
+	}
+
+	for i := 0; i < 21; i++ {
+		x, y, z := c[i], c[i+21], c[i+42]
+		switch i % 3 {
+		case 0:
+			write(x, y, z, 4)
+		case 1:
+			write(y, z, x, 4)
+		case 2:
+			write(z, x, y, 4)
+		}
+	}
+	write(0, 0, c[63], 2)
+}
@@ -0,0 +1,131 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// A Key is a verification key from a JWK set
+type Key struct {
+	Kid string
+	// Alg restricts the key to one algorithm when set
+	Alg string
+	// Key is a []byte, *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	Key interface{}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+
+	N string `json:"n"`
+	E string `json:"e"`
+	X string `json:"x"`
+	Y string `json:"y"`
+	K string `json:"k"`
+}
+
+// ParseJWKS reads the keys of a JSON Web Key Set (RFC 7517). Keys only meant for
+// encryption, or of types autoroute can't verify with, are skipped.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwks: %w", err)
+	}
+
+	var keys []Key
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("parsing jwk %q: %w", k.Kid, err)
+		}
+
+		if key == nil || (k.Alg != "" && !Fits(k.Alg, key)) {
+			continue
+		}
+
+		keys = append(keys, Key{Kid: k.Kid, Alg: k.Alg, Key: key})
+	}
+
+	return keys, nil
+}
+
+// key decodes the public key of a jwk, or returns nil for an unsupported type
+func (k jwk) key() (interface{}, error) {
+	switch {
+	case k.Kty == "oct":
+		return decodeField(k.K)
+	case k.Kty == "RSA":
+		n, err := decodeField(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeField(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 3 {
+			return nil, fmt.Errorf("bad exponent")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeField(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeField(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point isn't on the curve")
+		}
+
+		return key, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decodeField(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
+
+func decodeField(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing key field")
+	}
+
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,207 @@
+// Package jwt implements the parts of RFC 7515, 7518 and 7519 autoroute needs to check
+// bearer tokens: parsing compact JWS tokens and verifying their HS256, RS256, ES256
+// or EdDSA signatures, plus signing tokens for tests.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// the supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrKeyMismatch      = errors.New("key doesn't fit the signing algorithm")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Header is the JOSE header of a token
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// A Token is a parsed, but not yet verified, token
+type Token struct {
+	Header Header
+	// Claims is the raw JSON claims set
+	Claims json.RawMessage
+
+	signingInput []byte
+	signature    []byte
+}
+
+// Parse splits a compact token into its header, claims and signature
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	t := &Token{signingInput: []byte(parts[0] + "." + parts[1])}
+	err = json.Unmarshal(headerJSON, &t.Header)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	t.Claims, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !json.Valid(t.Claims) {
+		return nil, ErrMalformed
+	}
+
+	t.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return t, nil
+}
+
+// Fits reports whether key can verify tokens signed with alg, so tokens can't pick an
+// algorithm the key wasn't meant for, such as HS256 with an RSA public key as secret
+func Fits(alg string, key interface{}) bool {
+	switch k := key.(type) {
+	case []byte:
+		return alg == HS256
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		return alg == ES256 && k.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		return alg == EdDSA
+	}
+
+	return false
+}
+
+// Verify checks the token's signature with key
+func (t *Token) Verify(key interface{}) error {
+	switch t.Header.Alg {
+	case HS256, RS256, ES256, EdDSA:
+	default:
+		return ErrUnsupportedAlg
+	}
+
+	if !Fits(t.Header.Alg, key) {
+		return ErrKeyMismatch
+	}
+
+	digest := sha256.Sum256(t.signingInput)
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(t.signingInput)
+		if !hmac.Equal(mac.Sum(nil), t.signature) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], t.signature) != nil {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		// a fixed width r and s, rather than ASN.1
+		if len(t.signature) != 64 {
+			return ErrInvalidSignature
+		}
+
+		r := new(big.Int).SetBytes(t.signature[:32])
+		s := new(big.Int).SetBytes(t.signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, t.signingInput, t.signature) {
+			return ErrInvalidSignature
+		}
+	}
+
+	return nil
+}
+
+// Sign creates a compact token of claims, signed with key: a []byte secret for HS256,
+// or an *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
+func Sign(claims interface{}, kid string, key interface{}) (string, error) {
+	header := Header{Kid: kid, Typ: "JWT"}
+	switch key.(type) {
+	case []byte:
+		header.Alg = HS256
+	case *rsa.PrivateKey:
+		header.Alg = RS256
+	case *ecdsa.PrivateKey:
+		header.Alg = ES256
+	case ed25519.PrivateKey:
+		header.Alg = EdDSA
+	default:
+		return "", ErrUnsupportedAlg
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return "", ErrKeyMismatch
+		}
+
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		if err == nil {
+			signature = make([]byte, 64)
+			rb, sb := r.Bytes(), s.Bytes()
+			copy(signature[32-len(rb):32], rb)
+			copy(signature[64-len(sb):], sb)
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signingInput))
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,144 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		alg          string
+		private, pub interface{}
+	}{
+		{HS256, []byte("secret"), []byte("secret")},
+		{RS256, rsaKey, &rsaKey.PublicKey},
+		{ES256, ecKey, &ecKey.PublicKey},
+		{EdDSA, edKey, edPub},
+	}
+
+	for _, c := range cases {
+		token, err := Sign(map[string]string{"sub": "ian"}, "k1", c.private)
+		if err != nil {
+			t.Fatalf("%s: %v", c.alg, err)
+		}
+
+		parsed, err := Parse(token)
+		if err != nil {
+			t.Fatalf("%s: %v", c.alg, err)
+		}
+
+		if parsed.Header.Alg != c.alg || parsed.Header.Kid != "k1" || string(parsed.Claims) != `{"sub":"ian"}` {
+			t.Fatalf("%s: unexpected token %+v %s", c.alg, parsed.Header, parsed.Claims)
+		}
+
+		err = parsed.Verify(c.pub)
+		if err != nil {
+			t.Fatalf("%s: %v", c.alg, err)
+		}
+
+		// swap in different claims
+		parts := strings.Split(token, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+		tampered, err := Parse(strings.Join(parts, "."))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tampered.Verify(c.pub) != ErrInvalidSignature {
+			t.Fatalf("%s: expected a tampered token to fail", c.alg)
+		}
+	}
+
+	// an RSA public key can't be used as an HMAC secret
+	token, err := Sign(map[string]string{"sub": "ian"}, "", []byte(fmt.Sprint(rsaKey.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Verify(&rsaKey.PublicKey) != ErrKeyMismatch {
+		t.Fatal("expected the algorithm to have to fit the key")
+	}
+
+	// alg none is never accepted
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(`{}`)) + "."
+	parsed, err = Parse(none)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Verify([]byte("secret")) != ErrUnsupportedAlg {
+		t.Fatal("expected alg none to be refused")
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	t.Parallel()
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.", "e30.!!.", "e30.bm90IGpzb24."} {
+		_, err := Parse(token)
+		if err != ErrMalformed {
+			t.Fatalf("expected %q to be malformed, got %v", token, err)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	t.Parallel()
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": %q},
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": "c2VjcmV0"},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "EC", "crv": "P-384", "kid": "p384", "x": "AA", "y": "AA"},
+		{"kty": "oct", "kid": "mismatched", "alg": "RS256", "k": "c2VjcmV0"}
+	]}`, base64.RawURLEncoding.EncodeToString(edPub))
+
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0].Kid != "ed" || !bytes.Equal(edPub, keys[0].Key.(ed25519.PublicKey)) || keys[1].Kid != "hmac" || string(keys[1].Key.([]byte)) != "secret" {
+		t.Fatalf("expected the ed25519 and hmac keys, got %+v", keys)
+	}
+
+	_, err = ParseJWKS([]byte(`{"keys": [{"kty": "EC", "crv": "P-256", "kid": "off", "x": "AQ", "y": "AQ"}]}`))
+	if err == nil {
+		t.Fatal("expected a point off the curve to fail")
+	}
+}
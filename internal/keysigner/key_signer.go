@@ -7,23 +7,75 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
+// A Key is a secret and the ID values signed with it carry, so they can be verified
+// with the right key after the current one changes
+type Key struct {
+	ID     string
+	Secret string
+	// RetiresAt, when set, is when values signed with the key stop verifying
+	RetiresAt time.Time
+}
+
 // A KeySigner is used to verify the integrity of SessionKeys at the system borders
 type KeySigner struct {
-	key []byte
+	current Key
+	// keys are the keys values can be verified with, by ID. A key without an ID is one
+	// from NewKeySigner, whose values don't carry one.
+	keys map[string]Key
+
+	now func() time.Time
 }
 
 // NewKeySigner creates a new KeySigner with the given key
 func NewKeySigner(key string) *KeySigner {
+	k := Key{Secret: key}
+
 	return &KeySigner{
-		key: []byte(key),
+		current: k,
+		keys:    map[string]Key{"": k},
+		now:     time.Now,
 	}
 }
 
-// Sign appends an HMAC to a value
+// NewKeyringSigner creates a KeySigner which signs with current, naming it by its ID,
+// and verifies values signed with current or any of previous. A previous key without
+// an ID verifies values signed by a KeySigner from NewKeySigner.
+func NewKeyringSigner(current Key, previous ...Key) (*KeySigner, error) {
+	if current.ID == "" {
+		return nil, errors.New("keysigner: the current key needs an ID")
+	}
+
+	ks := &KeySigner{
+		current: current,
+		keys:    make(map[string]Key),
+		now:     time.Now,
+	}
+
+	for _, k := range append([]Key{current}, previous...) {
+		if strings.Contains(k.ID, ".") {
+			return nil, fmt.Errorf("keysigner: key ID %q can't contain a dot", k.ID)
+		}
+
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("keysigner: duplicate key ID %q", k.ID)
+		}
+
+		ks.keys[k.ID] = k
+	}
+
+	return ks, nil
+}
+
+// Sign appends an HMAC to a value, after the ID of the key when it has one
 func (ks *KeySigner) Sign(val string) (string, error) {
-	h := hmac.New(sha256.New, ks.key)
+	if ks.current.ID != "" {
+		val = val + "." + ks.current.ID
+	}
+
+	h := hmac.New(sha256.New, []byte(ks.current.Secret))
 	_, err := h.Write([]byte(val))
 	if err != nil {
 		return "", nil
@@ -34,26 +86,43 @@ func (ks *KeySigner) Sign(val string) (string, error) {
 
 // Verify checks a value signed with Sign
 func (ks *KeySigner) Verify(pubVal string) (string, error) {
-	h := hmac.New(sha256.New, ks.key)
-
-	spl := strings.Split(pubVal, ".")
-	if len(spl) != 2 {
+	// the value itself can contain dots, the signature can't
+	i := strings.LastIndex(pubVal, ".")
+	if i < 0 {
 		return "", errors.New("invalid token")
 	}
-
-	_, err := h.Write([]byte(spl[0]))
-	if err != nil {
-		return "", nil
-	}
+	spl := []string{pubVal[:i], pubVal[i+1:]}
 
 	hmacBytes, err := hex.DecodeString(spl[1])
 	if err != nil {
 		return "", err
 	}
 
-	if !hmac.Equal(hmacBytes, h.Sum(nil)) {
-		return "", errors.New("invalid signature")
+	// the signature covers the key ID, when there is one, along with the value
+	if j := strings.LastIndex(spl[0], "."); j >= 0 {
+		if k, ok := ks.keys[spl[0][j+1:]]; ok && k.ID != "" && ks.valid(k, spl[0], hmacBytes) {
+			return spl[0][:j], nil
+		}
+	}
+
+	if k, ok := ks.keys[""]; ok && ks.valid(k, spl[0], hmacBytes) {
+		return spl[0], nil
+	}
+
+	return "", errors.New("invalid signature")
+}
+
+// valid checks a signature made with k, which must not have retired
+func (ks *KeySigner) valid(k Key, signed string, sig []byte) bool {
+	if !k.RetiresAt.IsZero() && !ks.now().Before(k.RetiresAt) {
+		return false
+	}
+
+	h := hmac.New(sha256.New, []byte(k.Secret))
+	_, err := h.Write([]byte(signed))
+	if err != nil {
+		return false
 	}
 
-	return spl[0], nil
+	return hmac.Equal(sig, h.Sum(nil))
 }
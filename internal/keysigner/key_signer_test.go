@@ -2,7 +2,9 @@ package keysigner
 
 import (
 	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestKeySigner(t *testing.T) {
@@ -30,6 +32,37 @@ func TestKeySigner(t *testing.T) {
 					return errors.New("keys did not match")
 				}
 
+				return nil
+			},
+		},
+		{
+			"dotted value",
+			func(ks *KeySigner) error {
+				ogKey := "ian@example.com"
+				val, err := ks.Sign(ogKey)
+				if err != nil {
+					return err
+				}
+
+				key2, err := ks.Verify(val)
+				if err != nil {
+					return err
+				}
+
+				if key2 != ogKey {
+					return errors.New("keys did not match")
+				}
+
+				_, err = ks.Verify("ian@example.com." + val[len(ogKey)+1:])
+				if err != nil {
+					return err
+				}
+
+				_, err = ks.Verify("ian@example.org." + val[len(ogKey)+1:])
+				if err == nil {
+					return errors.New("verified a tampered value")
+				}
+
 				return nil
 			},
 		},
@@ -45,3 +78,93 @@ func TestKeySigner(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyringRotation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	legacy := NewKeySigner("legacy-key")
+	legacyVal, err := legacy.Sign("ian@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := NewKeyringSigner(Key{ID: "v1", Secret: "first-key"}, Key{Secret: "legacy-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1Val, err := v1.Sign("ian@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(v1Val, "ian@example.com.v1.") {
+		t.Fatalf("expected the value to carry its key ID, got %s", v1Val)
+	}
+
+	// v1 is retired a day after v2 takes over, by when everything should be re-signed
+	v2, err := NewKeyringSigner(Key{ID: "v2", Secret: "second-key"}, Key{ID: "v1", Secret: "first-key", RetiresAt: now.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2.now = func() time.Time { return now }
+	v2Val, err := v2.Sign("ian@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name   string
+		ks     *KeySigner
+		at     time.Time
+		val    string
+		verify bool
+	}{
+		{"legacy value before rotating", v1, now, legacyVal, true},
+		{"current value", v1, now, v1Val, true},
+		{"new value with old keys", v1, now, v2Val, false},
+		{"previous key in its window", v2, now.Add(time.Hour), v1Val, true},
+		{"previous key retired", v2, now.Add(24 * time.Hour), v1Val, false},
+		{"current key after the window", v2, now.Add(48 * time.Hour), v2Val, true},
+		{"dropped legacy key", v2, now, legacyVal, false},
+		{"wrong key ID", v2, now, strings.Replace(v1Val, ".v1.", ".v2.", 1), false},
+		{"legacy value without a legacy key", legacy, now, v1Val, false},
+	}
+
+	for _, tt := range cases {
+		at := tt.at
+		tt.ks.now = func() time.Time { return at }
+
+		val, err := tt.ks.Verify(tt.val)
+		if !tt.verify {
+			if err == nil {
+				t.Fatalf("%s: verified %s", tt.name, tt.val)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if val != "ian@example.com" {
+			t.Fatalf("%s: got %s", tt.name, val)
+		}
+	}
+}
+
+func TestKeyringSignerInvalidKeys(t *testing.T) {
+	t.Parallel()
+
+	for _, keys := range [][]Key{
+		{{Secret: "no-id"}},
+		{{ID: "v.1", Secret: "dotted"}},
+		{{ID: "v1", Secret: "a"}, {ID: "v1", Secret: "b"}},
+	} {
+		_, err := NewKeyringSigner(keys[0], keys[1:]...)
+		if err == nil {
+			t.Fatalf("expected an error for %v", keys)
+		}
+	}
+}
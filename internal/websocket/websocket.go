@@ -0,0 +1,458 @@
+// Package websocket implements the parts of RFC 6455 autoroute needs: upgrading an
+// http request, reading and writing (possibly fragmented) messages, and handling
+// the ping, pong and close control frames.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// message and control frame opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+// close status codes
+const (
+	CloseNormal           = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatus         = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+	maxControlPayloadSize = 125
+)
+
+// the GUID from section 1.3 used to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake  = errors.New("websocket: bad handshake")
+	ErrMessageTooBig = errors.New("websocket: message too big")
+)
+
+// A CloseError is returned by ReadMessage once the peer closes the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (ce *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", ce.Code, ce.Reason)
+}
+
+// A Conn is a websocket connection
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	server bool
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+	closeSent bool
+
+	// MaxMessageSize limits the size of a single (reassembled) message, zero means no limit
+	MaxMessageSize int64
+	// ReadTimeout, when set, fails ReadMessage if no frame at all arrives for that long
+	ReadTimeout time.Duration
+}
+
+func computeAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerHasToken reports whether a comma separated header contains token, ignoring case
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsUpgrade reports whether r asks to be upgraded to a websocket
+func IsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake of section 4.2 and takes over the
+// underlying connection. On failure it has already written an error response.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not implement http.Hijacker")
+	}
+
+	netConn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + computeAccept(key) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	// clear any deadlines the http server set
+	netConn.SetDeadline(time.Time{})
+
+	return &Conn{
+		conn:   netConn,
+		br:     rw.Reader,
+		server: true,
+	}, nil
+}
+
+// Dial opens a client connection to a ws:// url, mostly useful for tests
+func Dial(rawurl string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if u.Scheme != "ws" {
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	netConn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	err = req.Write(netConn)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != computeAccept(key) {
+		netConn.Close()
+		return nil, resp, ErrBadHandshake
+	}
+
+	return &Conn{
+		conn: netConn,
+		br:   br,
+	}, resp, nil
+}
+
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// noLimit lets readFrame read data frames of any size
+const noLimit = -1
+
+// readFrame reads a single frame, failing data frames longer than limit bytes before
+// allocating them. A limit of 0 only allows empty ones.
+func (c *Conn) readFrame(limit int64) (*frame, error) {
+	if c.ReadTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	}
+
+	var head [2]byte
+	_, err := io.ReadFull(c.br, head[:])
+	if err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		opcode: int(head[0] & 0x0f),
+	}
+
+	if head[0]&0x70 != 0 {
+		return nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+
+	masked := head[1]&0x80 != 0
+	if masked != c.server {
+		// clients must mask every frame, and servers must never
+		return nil, c.fail(CloseProtocolError, "bad masking")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	isControl := f.opcode&0x8 != 0
+	if isControl && (length > maxControlPayloadSize || !f.fin) {
+		return nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+
+	if !isControl && limit != noLimit && length > uint64(limit) {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.br, mask[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.br, f.payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+
+	return f, nil
+}
+
+// ReadMessage reads the next text or binary message, reassembling fragments. Pings are
+// answered and pongs are dropped along the way. Once the peer closes the connection the
+// close is echoed and a *CloseError returned.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	for {
+		remaining := int64(noLimit)
+		if c.MaxMessageSize > 0 {
+			remaining = c.MaxMessageSize - int64(len(data))
+		}
+
+		f, err := c.readFrame(remaining)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case OpPing:
+			err = c.writeFrame(OpPong, f.payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			ce := &CloseError{Code: CloseNoStatus}
+			if len(f.payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(f.payload))
+				ce.Reason = string(f.payload[2:])
+			}
+			c.WriteClose(ce.Code, "")
+			return 0, nil, ce
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			opcode = f.opcode
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		data = append(data, f.payload...)
+		if f.fin {
+			if opcode == OpText && !utf8.Valid(data) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8")
+			}
+			return opcode, data, nil
+		}
+	}
+}
+
+// fail closes the connection with a status code after a protocol violation
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return errors.New("websocket: close already sent")
+	}
+
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, len(payload)+14)
+	buf = append(buf, 0x80|byte(opcode))
+
+	var maskBit byte
+	if !c.server {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, maskBit|126, byte(length>>8), byte(length))
+	default:
+		buf = append(buf, maskBit|127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		buf = append(buf, ext[:]...)
+	}
+
+	if c.server {
+		buf = append(buf, payload...)
+	} else {
+		var mask [4]byte
+		_, err := rand.Read(mask[:])
+		if err != nil {
+			return err
+		}
+
+		buf = append(buf, mask[:]...)
+		for i, b := range payload {
+			buf = append(buf, b^mask[i%4])
+		}
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// WriteMessage writes a single, unfragmented text or binary message. It's safe to
+// call from multiple goroutines.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+// WritePing sends a ping, which the peer should answer with a pong
+func (c *Conn) WritePing(data []byte) error {
+	return c.writeFrame(OpPing, data)
+}
+
+// WriteClose starts (or answers) the closing handshake
+func (c *Conn) WriteClose(code int, reason string) error {
+	if code == CloseNoStatus {
+		return c.writeFrame(OpClose, nil)
+	}
+
+	if len(reason) > maxControlPayloadSize-2 {
+		reason = reason[:maxControlPayloadSize-2]
+	}
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	return c.writeFrame(OpClose, payload)
+}
+
+// Close closes the underlying connection without a closing handshake
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.conn.Close()
+	})
+
+	return err
+}
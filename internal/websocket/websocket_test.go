@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newEchoServer echoes every message back until the connection closes
+func newEchoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.MaxMessageSize = 16
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			conn.WriteMessage(opcode, data)
+		}
+	}))
+}
+
+func dial(t *testing.T, srv *httptest.Server) *Conn {
+	conn, _, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestComputeAccept(t *testing.T) {
+	// the example from section 1.3
+	if accept := computeAccept("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept %q", accept)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	srv := newEchoServer(t)
+	defer srv.Close()
+
+	conn := dial(t, srv)
+	defer conn.Close()
+
+	// a fragmented message, with a ping in the middle
+	_, err := conn.conn.Write(clientFrame(OpText, false, "hel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WritePing([]byte("p"))
+	conn.conn.Write(clientFrame(OpContinuation, true, "lo"))
+
+	// the pong is dropped by ReadMessage
+	opcode, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opcode != OpText || string(data) != "hello" {
+		t.Fatalf("unexpected echo %d %q", opcode, data)
+	}
+
+	err = conn.WriteClose(CloseNormal, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = conn.ReadMessage()
+	ce, ok := err.(*CloseError)
+	if !ok || ce.Code != CloseNormal {
+		t.Fatalf("expected a normal close, got %v", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	var cases = []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked", []byte{0x81, 0x01, 'a'}, CloseProtocolError},
+		{"invalid utf-8", clientFrame(OpText, true, "\xff"), CloseInvalidPayload},
+		{"too big", clientFrame(OpBinary, true, strings.Repeat("a", 17)), CloseMessageTooBig},
+		{"too big once the limit is filled", append(clientFrame(OpBinary, false, strings.Repeat("a", 16)), clientFrame(OpContinuation, true, "a")...), CloseMessageTooBig},
+		{"unexpected continuation", clientFrame(OpContinuation, true, "a"), CloseProtocolError},
+	}
+
+	srv := newEchoServer(t)
+	defer srv.Close()
+
+	for _, c := range cases {
+		conn := dial(t, srv)
+		_, err := conn.conn.Write(c.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = conn.ReadMessage()
+		ce, ok := err.(*CloseError)
+		if !ok || ce.Code != c.code {
+			t.Errorf("%s: expected a %d close, got %v", c.name, c.code, err)
+		}
+		conn.Close()
+	}
+}
+
+// clientFrame builds a small masked frame
+func clientFrame(opcode int, fin bool, payload string) []byte {
+	head := byte(opcode)
+	if fin {
+		head |= 0x80
+	}
+
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{head, 0x80 | byte(len(payload))}, mask...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	return frame
+}
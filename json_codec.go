@@ -3,18 +3,18 @@ package autoroute
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"reflect"
 )
 
 // JSONCodec implements autoroute functionality for the mime type application/json
-// and functions that have up to three input args and two output args.
-// a function with three input args must look like func(context.Context, autoroute.Header, anyStructOrPointer)
-// with two it can be func(context.Context, autoroute.Header) or func(context.Context, anyStructOrPointer)
-// with one it can be any of func(context.Context), func(autoroute.Header), or func(anyStructOrPointer)
+// and functions that have any number of input args and up to two output args.
+// input args can come in any order, and be any of context.Context, autoroute.Header,
+// http.Header (keeping every value), a struct with `header:"Name"` tagged fields decoded from
+// the headers, autoroute.Query, *http.Request, http.ResponseWriter, a type registered with
+// WithProvider, and at most one anyStructOrPointer decoded from the body,
+// so func(context.Context, autoroute.Header, anyStructOrPointer) is still the usual layout.
 // in terms of output values, a function can return `(anyStructOrPointer, error)`, `(anyStructOrPointer)`,
 // (error), or nothing.
 // the JSONCodec will attempt to decode values in two ways
@@ -30,151 +30,15 @@ func (js jsonCodec) Mime() string {
 }
 
 func (js jsonCodec) ValidFn(fn reflect.Value) error {
-	inputArgCount := fn.Type().NumIn()
-	if inputArgCount > 3 {
-		return ErrTooManyInputArgs
-	}
-
-	outputArgCount := fn.Type().NumOut()
-	if outputArgCount > 2 {
-		return ErrTooManyOutputArgs
-	}
-
-	return nil
+	return validValueFn(fn)
 }
 
 func (js jsonCodec) HandleRequest(cra *CodecRequestArgs) {
-	ctx := reflect.ValueOf(cra.Request.Context())
-	callArgs := make([]reflect.Value, cra.InputArgCount)
-	switch cra.InputArgCount {
-	case 3:
-		if cra.HandlerType.In(0).Kind() == reflect.Interface {
-			// if it implements context.Context
-			if contextType.Implements(cra.HandlerType.In(0)) {
-				callArgs[0] = ctx
-			} else {
-				panic("got a non context.Context interface type as the first arg")
-			}
-		} else {
-			panic("functions with two or more input args must have the first one be a context.Context")
-		}
-
-		if !(headerType == cra.HandlerType.In(1)) {
-			panic("autoroute: functions with three input args must have the second be an autoroute.Header")
-		} else {
-			callArgs[1] = reflect.ValueOf(cra.Header)
-		}
-
-		if cra.Request.Body == nil {
-			cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(errors.New("autoroute: request requires a body")))
-			return
-		}
-
-		callArg, err := js.decode(cra.HandlerType.In(2), cra.Request.Body, cra.MaxSizeBytes)
-		if err != nil {
-			cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(err))
-			return
-		}
-
-		callArgs[2] = callArg
-	case 2:
-		if cra.HandlerType.In(0).Kind() == reflect.Interface {
-			// if it implements context.Context
-			if contextType.Implements(cra.HandlerType.In(0)) {
-				callArgs[0] = ctx
-			} else {
-				panic("got a non context.Context interface type as the first arg")
-			}
-		} else {
-			panic("functions with two or more input args must have the first one be a context.Context")
-		}
-
-		handlerInArgType := cra.HandlerType.In(1)
-
-		var callArgOne reflect.Value
-		var err error
-		if headerType == handlerInArgType {
-			callArgOne = reflect.ValueOf(cra.Header)
-		} else {
-			if cra.Request.Body == nil {
-				cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(errors.New("autoroute: request requires a body")))
-				return
-			}
-
-			callArgOne, err = js.decode(handlerInArgType, cra.Request.Body, cra.MaxSizeBytes)
-		}
-		if err != nil {
-			cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(err))
-			return
-		}
-
-		callArgs[1] = callArgOne
-	case 1:
-		inArg := cra.HandlerType.In(0)
-		// here, our first arg is an interface
-		if inArg.Kind() == reflect.Interface {
-			// if it implements context.Context
-			if contextType.Implements(inArg) {
-				callArgs[0] = ctx
-			} else {
-				panic("got a non context.Context interface type as the first arg")
-			}
-		} else if headerType == inArg {
-			callArgs[0] = reflect.ValueOf(cra.Header)
-		} else {
-			if cra.Request.Body == nil {
-				cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(errors.New("autoroute: request requires a body")))
-				return
-			}
-
-			callArg, err := js.decode(inArg, cra.Request.Body, cra.MaxSizeBytes)
-			if err != nil {
-				cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(err))
-				return
-			}
-
-			callArgs[0] = callArg
-		}
-	case 0:
-		// do nothing
-	default:
-		panic("autoroute: can only have up to three input args")
-	}
-
-	outputValues := cra.HandlerFn.Call(callArgs)
-	cra.ResponseWriter.Header().Set("Content-Type", "application/json")
-	switch cra.OutputArgCount {
-	case 2:
-		// if err == nil
-		if outputValues[1].IsNil() {
-			err := json.NewEncoder(cra.ResponseWriter).Encode(outputValues[0].Interface())
-			if err != nil {
-				panic(err)
-			}
-			return
-		}
-
-		if outputValues[1].Kind() == reflect.Interface {
-			if outputValues[1].Type().ConvertibleTo(errorType) {
-				cra.ErrorHandler.Handle(cra.ResponseWriter, outputValues[1])
-				return
-			}
-		}
-	case 1:
-		if outputValues[0].Kind() == reflect.Interface {
-			if outputValues[0].Type().ConvertibleTo(errorType) {
-				cra.ErrorHandler.Handle(cra.ResponseWriter, outputValues[0])
-				return
-			}
-		}
+	handleValueRequest(js, cra)
+}
 
-		err := json.NewEncoder(cra.ResponseWriter).Encode(outputValues[0].Interface())
-		if err != nil {
-			panic(err)
-		}
-	case 0:
-		cra.ResponseWriter.WriteHeader(http.StatusOK)
-	}
+func (js jsonCodec) encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
 }
 
 func (js jsonCodec) decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error) {
@@ -185,16 +49,16 @@ func (js jsonCodec) decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes
 	var object reflect.Value
 
 	switch inArg.Kind() {
-	case reflect.Struct:
-		object = newReflectType(inArg)
-	case reflect.Ptr:
+	case reflect.Struct, reflect.Ptr:
 		object = newReflectType(inArg)
+	default:
+		// strings, slices, numbers and maps have no defaults to apply
+		object = reflect.New(inArg)
 	}
 
-	oi := object.Interface()
 	dec := json.NewDecoder(io.LimitReader(body, maxSizeBytes))
 	dec.DisallowUnknownFields()
-	err := dec.Decode(&oi)
+	err := dec.Decode(object.Interface())
 	if err != nil {
 		if err == io.EOF {
 			return reflect.Value{}, ErrDecodeFailure
@@ -204,12 +68,9 @@ func (js jsonCodec) decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes
 		}
 	}
 
-	switch inArg.Kind() {
-	case reflect.Struct:
-		return reflect.ValueOf(oi).Elem(), nil
-	case reflect.Ptr:
-		return reflect.ValueOf(oi), nil
+	if inArg.Kind() == reflect.Ptr {
+		return object, nil
 	}
 
-	return reflect.Value{}, ErrDecodeFailure
+	return object.Elem(), nil
 }
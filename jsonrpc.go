@@ -0,0 +1,284 @@
+package autoroute
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	// JSONRPCServerError is used for errors returned by middleware and functions,
+	// unless they return a *JSONRPCError themselves
+	JSONRPCServerError = -32000
+)
+
+// maxJSONRPCBytes limits the size of a whole JSON-RPC request, batches included (1 MiB)
+const maxJSONRPCBytes = 1 << 20
+
+// A JSONRPCError is the error object of a JSON-RPC 2.0 response. Functions can return
+// one to control the code and data sent back to the client.
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (je *JSONRPCError) Error() string {
+	return je.Message
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// JSONRPC serves every handler registered on the router, now or later, as a
+// JSON-RPC 2.0 method at path. Methods are named by WithName, or else by their function's
+// name, and take their body arg as by-name params (or a single by-position param). A name
+// shared by several handlers can't be called, give them each a WithName.
+// Batches and notifications are supported, and each call runs the handler's middleware
+// against a copy of the HTTP request.
+func (ro *Router) JSONRPC(path string) error {
+	return ro.Mount(path, &jsonRPCHandler{router: ro})
+}
+
+type jsonRPCHandler struct {
+	router *Router
+}
+
+func (jh *jsonRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONRPCBytes))
+	if err != nil {
+		writeJSONRPC(w, jsonRPCErrorResponse(jsonNull, JSONRPCParseError, err.Error()))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		err := json.Unmarshal(body, &batch)
+		if err != nil {
+			writeJSONRPC(w, jsonRPCErrorResponse(jsonNull, JSONRPCParseError, err.Error()))
+			return
+		}
+
+		if len(batch) == 0 {
+			writeJSONRPC(w, jsonRPCErrorResponse(jsonNull, JSONRPCInvalidRequest, "empty batch"))
+			return
+		}
+
+		responses := make([]*jsonRPCResponse, 0, len(batch))
+		for _, raw := range batch {
+			resp := jh.call(r, raw)
+			if resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+
+		if len(responses) == 0 {
+			// a batch of notifications
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeJSONRPC(w, responses)
+		return
+	}
+
+	if !json.Valid(body) {
+		writeJSONRPC(w, jsonRPCErrorResponse(jsonNull, JSONRPCParseError, "invalid json"))
+		return
+	}
+
+	resp := jh.call(r, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSONRPC(w, resp)
+}
+
+// call runs a single request object, returning nil for notifications
+func (jh *jsonRPCHandler) call(r *http.Request, raw json.RawMessage) *jsonRPCResponse {
+	var members map[string]json.RawMessage
+	err := json.Unmarshal(raw, &members)
+	if err != nil {
+		return jsonRPCErrorResponse(jsonNull, JSONRPCInvalidRequest, "request must be an object")
+	}
+
+	id, hasID := members["id"]
+	if !hasID {
+		id = jsonNull
+	} else if !validJSONRPCID(id) {
+		return jsonRPCErrorResponse(jsonNull, JSONRPCInvalidRequest, "id must be a string, number or null")
+	}
+
+	var version, method string
+	if json.Unmarshal(members["jsonrpc"], &version) != nil || version != "2.0" {
+		return jsonRPCErrorResponse(id, JSONRPCInvalidRequest, `jsonrpc must be "2.0"`)
+	}
+
+	if json.Unmarshal(members["method"], &method) != nil || method == "" {
+		return jsonRPCErrorResponse(id, JSONRPCInvalidRequest, "method must be a string")
+	}
+
+	resp := jh.invoke(r, method, members["params"])
+	resp.ID = id
+	if !hasID {
+		return nil
+	}
+
+	return resp
+}
+
+func (jh *jsonRPCHandler) invoke(r *http.Request, method string, params json.RawMessage) *jsonRPCResponse {
+	result, je := jh.router.invokeRPC(r, method, params, false)
+	if je != nil {
+		return &jsonRPCResponse{JSONRPC: "2.0", Error: je}
+	}
+
+	return &jsonRPCResponse{JSONRPC: "2.0", Result: result}
+}
+
+// invokeRPC calls the handler named method with params as its body arg, returning its
+// result (an explicit null when there's none) or a JSON-RPC error object. It's shared
+// by the RPC style transports, and those which already ran the router's middleware
+// for the connection skip it here.
+func (ro *Router) invokeRPC(r *http.Request, method string, params json.RawMessage, skipRouterMiddleware bool) (interface{}, *JSONRPCError) {
+	h, err := ro.handlerByName(method)
+	if err != nil {
+		return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: err.Error()}
+	}
+
+	invalidParams := &JSONRPCError{Code: JSONRPCInvalidParams, Message: "params must be an object or an array of one object"}
+	params = bytes.TrimSpace(params)
+	switch {
+	case len(params) == 0:
+		params = json.RawMessage("{}")
+	case params[0] == '[':
+		var positional []json.RawMessage
+		err := json.Unmarshal(params, &positional)
+		if err != nil || len(positional) > 1 {
+			return nil, invalidParams
+		}
+
+		params = json.RawMessage("{}")
+		if len(positional) == 1 {
+			params = positional[0]
+		}
+	case params[0] != '{':
+		return nil, invalidParams
+	}
+
+	if int64(len(params)) > h.maxSizeBytes {
+		return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "params too large"}
+	}
+
+	callReq := r.Clone(r.Context())
+	callReq.Body = ioutil.NopCloser(bytes.NewReader(params))
+	callReq.ContentLength = int64(len(params))
+	callReq.Header.Set(MimeTypeHeader, "application/json")
+
+	middlewares := h.middlewares
+	if skipRouterMiddleware {
+		middlewares = middlewares[h.routerMiddlewares:]
+	}
+
+	result, ie := h.invoke(callReq, middlewares)
+	if ie != nil {
+		switch ie.step {
+		case invokeDecode:
+			je := &JSONRPCError{Code: JSONRPCInvalidParams, Message: ie.Error()}
+			var ve *ValidationError
+			if errors.As(ie.err, &ve) {
+				je.Data = map[string]interface{}{"fields": ve.Fields}
+			}
+
+			return nil, je
+		case invokePanic:
+			return nil, &JSONRPCError{Code: JSONRPCInternalError, Message: ie.Error()}
+		}
+
+		var je *JSONRPCError
+		if errors.As(ie.err, &je) {
+			return nil, je
+		}
+
+		je = &JSONRPCError{Code: JSONRPCServerError, Message: ie.Error()}
+		var mwe MiddlewareError
+		if errors.As(ie.err, &mwe) {
+			je.Data = map[string]interface{}{"status": mwe.StatusCode}
+		}
+
+		return nil, je
+	}
+
+	if result == nil {
+		// result is required on success, so send an explicit null
+		return jsonNull, nil
+	}
+
+	// encode now, so a result that can't be is an error for this call alone
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()}
+	}
+
+	return json.RawMessage(data), nil
+}
+
+func validJSONRPCID(id json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+
+	return false
+}
+
+func jsonRPCErrorResponse(id json.RawMessage, code int, message string) *jsonRPCResponse {
+	return &jsonRPCResponse{
+		JSONRPC: "2.0",
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+		},
+		ID: id,
+	}
+}
+
+func writeJSONRPC(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		buf.Reset()
+		json.NewEncoder(&buf).Encode(jsonRPCErrorResponse(jsonNull, JSONRPCInternalError, err.Error()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,189 @@
+package autoroute
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newJSONRPCRouter(t *testing.T, ts *TestServer) *Router {
+	r, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Register(http.MethodPost, "/thing", ts.DoThing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Register(http.MethodPost, "/error", ts.DoThingErrorReturn, WithName("fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Register(http.MethodPost, "/restricted", ts.DoThingValueArgs, WithMiddleware(NewBasicAuthMiddleware("user", "user")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.JSONRPC("/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestJSONRPC(t *testing.T) {
+	t.Parallel()
+
+	var cases = []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			"call",
+			`{"jsonrpc": "2.0", "method": "DoThing", "params": {"input": "yo"}, "id": 1}`,
+			`{"jsonrpc":"2.0","result":{"output":"hi"},"id":1}`,
+		},
+		{
+			"positional params",
+			`{"jsonrpc": "2.0", "method": "DoThing", "params": [{"input": "yo"}], "id": "a"}`,
+			`{"jsonrpc":"2.0","result":{"output":"hi"},"id":"a"}`,
+		},
+		{
+			"named handler error",
+			`{"jsonrpc": "2.0", "method": "fail", "id": 2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"sup"},"id":2}`,
+		},
+		{
+			"parse error",
+			`{"jsonrpc": "2.0", "method": "DoThing", "params": "`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid json"},"id":null}`,
+		},
+		{
+			"invalid request",
+			`{"jsonrpc": "1.0", "method": "DoThing", "id": 3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc must be \"2.0\""},"id":3}`,
+		},
+		{
+			"method not found",
+			`{"jsonrpc": "2.0", "method": "nope", "id": 4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":4}`,
+		},
+		{
+			"invalid params",
+			`{"jsonrpc": "2.0", "method": "DoThing", "params": {"unknown": 1}, "id": 5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"json: unknown field \"unknown\""},"id":5}`,
+		},
+		{
+			"middleware",
+			`{"jsonrpc": "2.0", "method": "DoThingValueArgs", "params": {"input": "yo"}, "id": 6}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"basic auth required","data":{"status":401}},"id":6}`,
+		},
+		{
+			"batch",
+			`[
+				{"jsonrpc": "2.0", "method": "DoThing", "params": {"input": "yo"}, "id": 1},
+				{"jsonrpc": "2.0", "method": "DoThing", "params": {"input": "notified"}},
+				{"foo": "bar"}
+			]`,
+			`[{"jsonrpc":"2.0","result":{"output":"hi"},"id":1},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc must be \"2.0\""},"id":null}]`,
+		},
+		{
+			"empty batch",
+			`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		},
+	}
+
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			router := newJSONRPCRouter(t, &TestServer{})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(w, req)
+
+			diffJSON(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func TestJSONRPCNotification(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+	router := newJSONRPCRouter(t, ts)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc": "2.0", "method": "DoThing", "params": {"input": "yo"}}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("expected an empty response, got %d %q", w.Code, w.Body.String())
+	}
+
+	if ts.input != "yo" {
+		t.Fatal("did not call the function for a notification")
+	}
+}
+
+func TestJSONRPCUnencodableResult(t *testing.T) {
+	t.Parallel()
+	router := newJSONRPCRouter(t, &TestServer{})
+
+	err := router.Register(http.MethodPost, "/chan", func() <-chan int {
+		return make(chan int)
+	}, WithName("chan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`[{"jsonrpc": "2.0", "method": "chan", "id": 1}, {"jsonrpc": "2.0", "method": "DoThing", "params": {"input": "yo"}, "id": 2}]`))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	diffJSON(t, `[{"jsonrpc":"2.0","error":{"code":-32603,"message":"json: unsupported type: \u003c-chan int"},"id":1},{"jsonrpc":"2.0","result":{"output":"hi"},"id":2}]`, w.Body.String())
+}
+
+func TestJSONRPCAmbiguousMethod(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+	router := newJSONRPCRouter(t, ts)
+
+	// the same function at a second route shares its name
+	err := router.Register(http.MethodPut, "/thing", ts.DoThing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/thing-again", ts.DoThing, WithName("DoThingAgain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method, expected string
+	}{
+		{"DoThing", `{"jsonrpc":"2.0","error":{"code":-32601,"message":"ambiguous method, more than one handler has this name"},"id":1}`},
+		{"DoThingAgain", `{"jsonrpc":"2.0","result":{"output":"hi"},"id":1}`},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"`+tc.method+`","params":{"input":"yo"},"id":1}`))
+		router.ServeHTTP(w, req)
+
+		diffJSON(t, tc.expected, w.Body.String())
+	}
+}
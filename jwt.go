@@ -0,0 +1,478 @@
+package autoroute
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonaut/autoroute/internal/jwt"
+)
+
+var (
+	ErrMissingBearerToken = errors.New("autoroute: missing bearer token")
+	ErrInvalidBearerToken = errors.New("autoroute: invalid bearer token")
+	ErrBadJWTKey          = errors.New("autoroute: jwt keys must be a []byte secret, *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey")
+)
+
+// how often a JWKS document is fetched again, for new keys or when a token names a key
+// we haven't seen
+const (
+	jwksMaxAge     = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+// Claims are the verified claims of a bearer token. Functions behind JWTMiddleware can
+// take a *Claims arg.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+
+	// Raw holds every claim, including the ones above, with numbers as json.Number
+	Raw map[string]interface{}
+}
+
+func parseClaims(data []byte) (*Claims, error) {
+	var std struct {
+		Iss string          `json:"iss"`
+		Sub string          `json:"sub"`
+		Aud json.RawMessage `json:"aud"`
+		Exp *json.Number    `json:"exp"`
+		Nbf *json.Number    `json:"nbf"`
+		Iat *json.Number    `json:"iat"`
+		Jti string          `json:"jti"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	c := &Claims{}
+	err := dec.Decode(&c.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &std)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Issuer, c.Subject, c.ID = std.Iss, std.Sub, std.Jti
+
+	// aud can be a single string or a list of them
+	if len(std.Aud) > 0 && string(std.Aud) != "null" {
+		if std.Aud[0] == '"' {
+			c.Audience = make([]string, 1)
+			err = json.Unmarshal(std.Aud, &c.Audience[0])
+		} else {
+			err = json.Unmarshal(std.Aud, &c.Audience)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, nd := range []struct {
+		n *json.Number
+		t *time.Time
+	}{{std.Exp, &c.ExpiresAt}, {std.Nbf, &c.NotBefore}, {std.Iat, &c.IssuedAt}} {
+		if nd.n == nil {
+			continue
+		}
+
+		secs, err := nd.n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		*nd.t = time.Unix(0, int64(secs*float64(time.Second)))
+	}
+
+	return c, nil
+}
+
+type JWTOption func(jm *JWTMiddleware)
+
+// WithJWTKey verifies tokens with a key: a []byte secret for HS256, or an
+// *rsa.PublicKey, *ecdsa.PublicKey on P-256 or ed25519.PublicKey. kid can be empty,
+// otherwise only tokens naming it in their header use the key.
+func WithJWTKey(kid string, key interface{}) JWTOption {
+	return func(jm *JWTMiddleware) {
+		switch key.(type) {
+		case []byte, *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			jm.keys = append(jm.keys, jwt.Key{Kid: kid, Key: key})
+		default:
+			jm.setErr(ErrBadJWTKey)
+		}
+	}
+}
+
+// WithJWKSFile verifies tokens with the keys of a JSON Web Key Set file
+func WithJWKSFile(path string) JWTOption {
+	return func(jm *JWTMiddleware) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			jm.setErr(err)
+			return
+		}
+
+		keys, err := jwt.ParseJWKS(data)
+		if err != nil {
+			jm.setErr(err)
+			return
+		}
+		jm.keys = append(jm.keys, keys...)
+	}
+}
+
+// WithJWKSURL verifies tokens with the keys of a JSON Web Key Set served at url, which
+// is fetched on the first request, every hour after, and when a token names a key it
+// doesn't have, at most once a minute
+func WithJWKSURL(url string) JWTOption {
+	return func(jm *JWTMiddleware) {
+		jm.jwksURL = url
+	}
+}
+
+// WithJWTIssuer only accepts tokens whose iss claim is issuer
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(jm *JWTMiddleware) {
+		jm.issuer = issuer
+	}
+}
+
+// WithJWTAudience only accepts tokens whose aud claim has one of audiences
+func WithJWTAudience(audiences ...string) JWTOption {
+	return func(jm *JWTMiddleware) {
+		jm.audiences = append(jm.audiences, audiences...)
+	}
+}
+
+// WithJWTClockSkew sets how far the exp and nbf claims can be missed by, 30 seconds
+// by default, for servers whose clocks disagree
+func WithJWTClockSkew(d time.Duration) JWTOption {
+	return func(jm *JWTMiddleware) {
+		jm.skew = d
+	}
+}
+
+// JWTMiddleware authenticates requests with an `Authorization: Bearer` token, answering
+// a 401 with a WWW-Authenticate challenge when it's missing or invalid. Tokens are
+// JWS compact tokens signed with HS256, RS256, ES256 or EdDSA, whose exp, nbf, iss and
+// aud claims are checked. Functions can take the verified claims as a *Claims arg.
+type JWTMiddleware struct {
+	keys      []jwt.Key
+	issuer    string
+	audiences []string
+	skew      time.Duration
+
+	jwksURL string
+	// the keys last fetched from jwksURL
+	jwksMu        sync.Mutex
+	jwksKeys      []jwt.Key
+	jwksErr       error
+	jwksFetched   time.Time
+	jwksAttempted time.Time
+	// closed when the fetch in progress, if any, is done
+	jwksFetching chan struct{}
+
+	now func() time.Time
+	err error
+}
+
+func NewJWTMiddleware(opts ...JWTOption) (*JWTMiddleware, error) {
+	jm := &JWTMiddleware{
+		skew: 30 * time.Second,
+		now:  time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(jm)
+	}
+
+	if jm.err != nil {
+		return nil, jm.err
+	}
+
+	if len(jm.keys) == 0 && jm.jwksURL == "" {
+		return nil, errors.New("autoroute: a JWTMiddleware needs keys, a JWKS file or a JWKS url")
+	}
+
+	return jm, nil
+}
+
+func (jm *JWTMiddleware) setErr(err error) {
+	if jm.err == nil {
+		jm.err = err
+	}
+}
+
+func (jm *JWTMiddleware) Before(r *http.Request, h *Handler) error {
+	_, err := jm.BeforeRequest(r, h)
+	return err
+}
+
+func (jm *JWTMiddleware) BeforeRequest(r *http.Request, h *Handler) (*http.Request, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil, jm.unauthorized(ErrMissingBearerToken, "")
+	}
+
+	claims, err := jm.Verify(r.Context(), strings.TrimSpace(auth[7:]))
+	if err != nil {
+		var fe *jwksFetchError
+		if errors.As(err, &fe) {
+			// our failure rather than the token's, so a 500
+			return nil, err
+		}
+
+		return nil, jm.unauthorized(fmt.Errorf("%w: %v", ErrInvalidBearerToken, err), err.Error())
+	}
+
+	return SetValue(r, claims), nil
+}
+
+func (jm *JWTMiddleware) Values() []interface{} {
+	return []interface{}{(*Claims)(nil)}
+}
+
+// unauthorized builds a 401 with a challenge, describing why a token was refused
+func (jm *JWTMiddleware) unauthorized(err error, description string) MiddlewareError {
+	challenge := "Bearer"
+	if description != "" {
+		challenge += fmt.Sprintf(` error="invalid_token", error_description=%q`, description)
+	}
+
+	return MiddlewareError{
+		StatusCode: http.StatusUnauthorized,
+		Err:        err,
+		Header:     http.Header{"Www-Authenticate": {challenge}},
+	}
+}
+
+// Verify checks a token's signature and claims, returning the claims
+func (jm *JWTMiddleware) Verify(ctx context.Context, token string) (*Claims, error) {
+	parsed, err := jwt.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jm.verifySignature(ctx, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := parseClaims(parsed.Claims)
+	if err != nil {
+		return nil, errors.New("malformed claims")
+	}
+
+	now := jm.now()
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(jm.skew)) {
+		return nil, errors.New("token is expired")
+	}
+
+	if !claims.NotBefore.IsZero() && now.Add(jm.skew).Before(claims.NotBefore) {
+		return nil, errors.New("token isn't valid yet")
+	}
+
+	if jm.issuer != "" && claims.Issuer != jm.issuer {
+		return nil, errors.New("token has the wrong issuer")
+	}
+
+	if len(jm.audiences) > 0 && !audienceMatches(claims.Audience, jm.audiences) {
+		return nil, errors.New("token has the wrong audience")
+	}
+
+	return claims, nil
+}
+
+func audienceMatches(audience, allowed []string) bool {
+	for _, aud := range audience {
+		for _, a := range allowed {
+			if aud == a {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verifySignature tries every key that could have signed the token, fetching the
+// JWKS again when none of them are named by it
+func (jm *JWTMiddleware) verifySignature(ctx context.Context, token *jwt.Token) error {
+	keys, named := jm.candidates(token.Header, jm.keys)
+	if jm.jwksURL != "" {
+		jwksKeys, err := jm.jwks(ctx, false)
+		if err != nil {
+			return err
+		}
+
+		more, moreNamed := jm.candidates(token.Header, jwksKeys)
+		if token.Header.Kid != "" && !named && !moreNamed {
+			// maybe the key is new
+			jwksKeys, err = jm.jwks(ctx, true)
+			if err != nil {
+				return err
+			}
+			more, _ = jm.candidates(token.Header, jwksKeys)
+		}
+
+		keys = append(keys, more...)
+	}
+
+	if len(keys) == 0 {
+		return errors.New("no key fits the token")
+	}
+
+	for _, key := range keys {
+		if token.Verify(key.Key) == nil {
+			return nil
+		}
+	}
+
+	return jwt.ErrInvalidSignature
+}
+
+// candidates returns the keys of a set which fit a token's header, and whether the
+// set has the key the header names
+func (jm *JWTMiddleware) candidates(header jwt.Header, set []jwt.Key) ([]jwt.Key, bool) {
+	var keys []jwt.Key
+	named := false
+	for _, key := range set {
+		if header.Kid != "" && key.Kid != "" && key.Kid != header.Kid {
+			continue
+		}
+
+		if key.Kid != "" && key.Kid == header.Kid {
+			named = true
+		}
+
+		if (key.Alg == "" || key.Alg == header.Alg) && jwt.Fits(header.Alg, key.Key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, named
+}
+
+// jwks returns the keys fetched from jwksURL, fetching them when they're stale or when
+// refresh asks for it and they haven't been fetched for a while. Fetching errors are
+// only returned when there are no keys to fall back on.
+// Every request shares a single fetch, which outlives the request that started it.
+// Stale keys are served while it runs, others wait for it until their ctx is done.
+func (jm *JWTMiddleware) jwks(ctx context.Context, refresh bool) ([]jwt.Key, error) {
+	jm.jwksMu.Lock()
+
+	now := jm.now()
+	fetched := !jm.jwksFetched.IsZero()
+	stale := !fetched || now.Sub(jm.jwksFetched) > jwksMaxAge || refresh
+	// the backoff only protects the JWKS endpoint once there are keys to fall back on
+	backoff := fetched && now.Sub(jm.jwksAttempted) < jwksMinRefresh
+	if !stale || backoff {
+		keys := jm.jwksKeys
+		jm.jwksMu.Unlock()
+		return keys, nil
+	}
+
+	if jm.jwksFetching == nil {
+		jm.jwksAttempted = now
+		jm.jwksFetching = make(chan struct{})
+		go jm.fetchJWKS(now, jm.jwksFetching)
+	}
+	fetching := jm.jwksFetching
+
+	if fetched && !refresh {
+		keys := jm.jwksKeys
+		jm.jwksMu.Unlock()
+		return keys, nil
+	}
+	jm.jwksMu.Unlock()
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return nil, &jwksFetchError{err: ctx.Err()}
+	}
+
+	jm.jwksMu.Lock()
+	defer jm.jwksMu.Unlock()
+
+	if jm.jwksKeys == nil && jm.jwksErr != nil {
+		return nil, &jwksFetchError{err: jm.jwksErr}
+	}
+
+	return jm.jwksKeys, nil
+}
+
+// fetchJWKS fetches the keys on behalf of every request waiting for them, closing done
+// once they're stored
+func (jm *JWTMiddleware) fetchJWKS(now time.Time, done chan struct{}) {
+	keys, err := fetchJWKS(context.Background(), jm.jwksURL)
+
+	jm.jwksMu.Lock()
+	defer jm.jwksMu.Unlock()
+
+	jm.jwksErr = err
+	if err == nil {
+		jm.jwksKeys = keys
+		jm.jwksFetched = now
+	}
+
+	jm.jwksFetching = nil
+	close(done)
+}
+
+// a jwksFetchError is a failure to fetch keys, rather than a problem with a token
+type jwksFetchError struct {
+	err error
+}
+
+func (fe *jwksFetchError) Error() string {
+	return "autoroute: fetching jwks: " + fe.err.Error()
+}
+
+func (fe *jwksFetchError) Unwrap() error {
+	return fe.err
+}
+
+func fetchJWKS(ctx context.Context, url string) ([]jwt.Key, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.ParseJWKS(data)
+}
@@ -0,0 +1,299 @@
+package autoroute
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autonaut/autoroute/internal/jwt"
+)
+
+func jwtRouter(t *testing.T, jm *JWTMiddleware) *Router {
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(jm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodGet, "/me", func(c *Claims) TestOutput {
+		return TestOutput{Output: c.Subject + " " + c.Raw["name"].(string)}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return router
+}
+
+func sendBearer(router *Router, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(MimeTypeHeader, "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func signJWT(t *testing.T, claims map[string]interface{}, kid string, key interface{}) string {
+	token, err := jwt.Sign(claims, kid, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1600000000, 0)
+	secret := []byte("test-secret")
+	jm, err := NewJWTMiddleware(WithJWTKey("", secret), WithJWTIssuer("https://issuer.example.com"), WithJWTAudience("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm.now = func() time.Time { return now }
+
+	router := jwtRouter(t, jm)
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":  "ian",
+			"name": "Ian",
+			"iss":  "https://issuer.example.com",
+			"aud":  []string{"billing", "orders"},
+			"exp":  now.Unix() + 60,
+			"nbf":  now.Unix() - 60,
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	w := sendBearer(router, signJWT(t, claims(nil), "", secret))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d %s", w.Code, w.Body.String())
+	}
+	diffJSON(t, `{"output":"ian Ian"}`, w.Body.String())
+
+	w = sendBearer(router, "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("expected a 401 challenge, got %d %v", w.Code, w.Header())
+	}
+
+	tcs := []struct {
+		name   string
+		claims map[string]interface{}
+		key    interface{}
+		reason string
+	}{
+		{"expired", map[string]interface{}{"exp": now.Unix() - 31}, secret, "token is expired"},
+		{"not yet valid", map[string]interface{}{"nbf": now.Unix() + 31}, secret, "token isn't valid yet"},
+		{"wrong issuer", map[string]interface{}{"iss": "https://evil.example.com"}, secret, "token has the wrong issuer"},
+		{"wrong audience", map[string]interface{}{"aud": "billing"}, secret, "token has the wrong audience"},
+		{"wrong key", nil, []byte("other-secret"), "invalid signature"},
+	}
+
+	for _, tc := range tcs {
+		w = sendBearer(router, signJWT(t, claims(tc.claims), "", tc.key))
+		expected := `Bearer error="invalid_token", error_description="` + tc.reason + `"`
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != expected {
+			t.Fatalf("%s: expected a 401 with %q, got %d %v", tc.name, expected, w.Code, w.Header())
+		}
+	}
+
+	// within the clock skew, and a single audience string
+	w = sendBearer(router, signJWT(t, claims(map[string]interface{}{"exp": now.Unix() - 29, "aud": "orders"}), "", secret))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected clock skew to be allowed, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// jwk encodes a public key as a JSON Web Key
+func jwk(kid string, key interface{}) map[string]string {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "crv": "P-256", "kid": kid, "x": b64(k.X.Bytes()), "y": b64(k.Y.Bytes())}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": b64(k)}
+	}
+
+	return nil
+}
+
+func TestJWTMiddlewareJWKS(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	keys := []map[string]string{jwk("rsa", &rsaKey.PublicKey), jwk("ec", &ecKey.PublicKey)}
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer jwks.Close()
+
+	now := time.Now()
+	jm, err := NewJWTMiddleware(WithJWKSURL(jwks.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm.now = func() time.Time { return now }
+
+	router := jwtRouter(t, jm)
+	claims := map[string]interface{}{"sub": "ian", "name": "Ian", "exp": now.Unix() + 3600}
+
+	for _, token := range []string{signJWT(t, claims, "rsa", rsaKey), signJWT(t, claims, "ec", ecKey)} {
+		w := sendBearer(router, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected a 200, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	// a key published after the last fetch is picked up once a minute has passed
+	mu.Lock()
+	keys = append(keys, jwk("ed", edPub))
+	mu.Unlock()
+
+	edToken := signJWT(t, claims, "ed", edKey)
+	if w := sendBearer(router, edToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the new key to be unknown for now, got %d", w.Code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if w := sendBearer(router, edToken); w.Code != http.StatusOK {
+		t.Fatalf("expected the new key to be fetched, got %d %s", w.Code, w.Body.String())
+	}
+
+	if fetches != 2 {
+		t.Fatalf("expected 2 fetches, got %d", fetches)
+	}
+
+	// the same keys from a file
+	f, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	err = json.NewEncoder(f).Encode(map[string]interface{}{"keys": keys})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jm, err = NewJWTMiddleware(WithJWKSFile(f.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := sendBearer(jwtRouter(t, jm), edToken); w.Code != http.StatusOK {
+		t.Fatalf("expected a 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	// an unreachable JWKS is our problem rather than the token's
+	jm, err = NewJWTMiddleware(WithJWKSURL("http://127.0.0.1:1/jwks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := sendBearer(jwtRouter(t, jm), edToken)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "fetching jwks") {
+		t.Fatalf("expected a 500, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJWTMiddlewareJWKSFetch(t *testing.T) {
+	t.Parallel()
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	fetches := 0
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if fetches == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{jwk("ed", edPub)}})
+	}))
+	defer jwks.Close()
+
+	jm, err := NewJWTMiddleware(WithJWKSURL(jwks.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signJWT(t, map[string]interface{}{"sub": "ian"}, "ed", edKey)
+
+	// a client going away doesn't cancel the fetch it started, or hold up the next one
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = jm.Verify(ctx, token)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("expected the canceled request to give up, got %v", err)
+	}
+
+	close(release)
+	_, err = jm.Verify(context.Background(), token)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected the failed fetch's error, got %v", err)
+	}
+
+	// with nothing fetched yet there's no backoff
+	_, err = jm.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 2 {
+		t.Fatalf("expected 2 fetches, got %d", fetches)
+	}
+}
@@ -1,8 +1,9 @@
 package autoroute
 
 import (
-	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/autonaut/autoroute/internal/keysigner"
 )
@@ -15,9 +16,30 @@ type Middleware interface {
 	Before(r *http.Request, h *Handler) error
 }
 
+// AfterMiddleware is Middleware which also sees the response. After is called once the
+// function has run and its response has been written, so it can observe the status
+// code, log, or time the request, but it's too late to change headers.
+type AfterMiddleware interface {
+	Middleware
+
+	After(w ResponseWriter, r *http.Request, h *Handler)
+}
+
+// AroundMiddleware is Middleware which wraps the rest of the request, Before included,
+// as the outermost layer. Around is called per request with next, the rest of the
+// chain, and can wrap w to add or change response headers before they're written.
+// Middleware given first wraps the middleware given after it.
+type AroundMiddleware interface {
+	Middleware
+
+	Around(h *Handler, next http.Handler) http.Handler
+}
+
 type MiddlewareError struct {
 	StatusCode int
 	Err        error
+	// Header is added to the response, such as a Retry-After
+	Header http.Header
 }
 
 func (mwe MiddlewareError) Error() string {
@@ -26,7 +48,9 @@ func (mwe MiddlewareError) Error() string {
 
 // SignedHeadersMiddleware validates that all incoming headers are signed using a certain key
 // if they're set as a header outgoing, they'll also be signed on the way out.
-// this works great for cookies.
+// this works great for cookies: a request can send a cookie of the same name instead of
+// the header, and Set-Cookie values of that name are signed on the way out too. Cookie
+// signatures cover the cookie's name, so they can't be moved to another cookie or header.
 type SignedHeadersMiddleware struct {
 	ks      *keysigner.KeySigner
 	headers []string
@@ -41,9 +65,54 @@ func NewSignedHeadersMiddleware(headers []string, key string) *SignedHeadersMidd
 	}
 }
 
+// A SigningKey is one of the keys of a keyring. Values are signed with its ID, so
+// they're checked with the key that signed them.
+type SigningKey struct {
+	// ID names the key in signed values, it can't contain a dot
+	ID     string
+	Secret string
+	// RetiresAt, when set, is when values signed with the key stop being accepted
+	RetiresAt time.Time
+}
+
+// NewSignedHeadersMiddlewareWithKeyring signs with current, and accepts values signed
+// with current or any of previous, so the key can be rotated without refusing every
+// value already handed out. A previous key without an ID accepts values signed by
+// NewSignedHeadersMiddleware, for moving from a single key.
+func NewSignedHeadersMiddlewareWithKeyring(headers []string, current SigningKey, previous ...SigningKey) (*SignedHeadersMiddleware, error) {
+	keys := make([]keysigner.Key, 0, len(previous))
+	for _, k := range previous {
+		keys = append(keys, keysigner.Key(k))
+	}
+
+	ks, err := keysigner.NewKeyringSigner(keysigner.Key(current), keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedHeadersMiddleware{
+		headers: headers,
+		ks:      ks,
+	}, nil
+}
+
 func (shm *SignedHeadersMiddleware) Before(r *http.Request, h *Handler) error {
 	for _, h := range shm.headers {
 		hVal := r.Header.Get(h)
+		if hVal == "" {
+			if c, err := r.Cookie(h); err == nil {
+				verified, err := verifyCookie(shm.ks, h, c.Value)
+				if err != nil {
+					return MiddlewareError{
+						StatusCode: http.StatusForbidden,
+						Err:        err,
+					}
+				}
+
+				replaceCookie(r, h, verified)
+				continue
+			}
+		}
 
 		verified, err := shm.ks.Verify(hVal)
 		if err != nil {
@@ -59,6 +128,36 @@ func (shm *SignedHeadersMiddleware) Before(r *http.Request, h *Handler) error {
 	return nil
 }
 
+// Around signs the configured headers, and Set-Cookie values of the same names, as
+// the response is written
+func (shm *SignedHeadersMiddleware) Around(h *Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &signingWriter{ResponseWriter: newResponseWriter(w), shm: shm}
+		next.ServeHTTP(sw, r)
+
+		// nothing written yet means the server writes the headers once we return
+		sw.sign()
+	})
+}
+
+// Signer returns middleware which only signs outgoing headers, for routes like a login
+// that hand out values before the client has any to send back
+func (shm *SignedHeadersMiddleware) Signer() AroundMiddleware {
+	return signOnlyMiddleware{shm}
+}
+
+type signOnlyMiddleware struct {
+	shm *SignedHeadersMiddleware
+}
+
+func (som signOnlyMiddleware) Before(r *http.Request, h *Handler) error {
+	return nil
+}
+
+func (som signOnlyMiddleware) Around(h *Handler, next http.Handler) http.Handler {
+	return som.shm.Around(h, next)
+}
+
 func (shm *SignedHeadersMiddleware) Verify(value string) (string, error) {
 	return shm.ks.Verify((value))
 }
@@ -67,32 +166,82 @@ func (shm *SignedHeadersMiddleware) Sign(value string) (string, error) {
 	return shm.ks.Sign((value))
 }
 
-type BasicAuthMiddleware struct {
-	username, password string
-}
+// signHeaders signs the configured headers and cookies of an outgoing header
+func (shm *SignedHeadersMiddleware) signHeaders(header http.Header) {
+	for _, name := range shm.headers {
+		if v := header.Get(name); v != "" {
+			signed, err := shm.ks.Sign(v)
+			if err == nil {
+				header.Set(name, signed)
+			}
+		}
+	}
+
+	setCookies := header["Set-Cookie"]
+	for i, line := range setCookies {
+		// sign just the value, bound to the cookie's name, keeping every attribute as written
+		attrs := ""
+		if semi := strings.Index(line, ";"); semi >= 0 {
+			line, attrs = line[:semi], line[semi:]
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+
+		name, value := strings.TrimSpace(line[:eq]), line[eq+1:]
+		for _, h := range shm.headers {
+			if !strings.EqualFold(name, h) {
+				continue
+			}
 
-func NewBasicAuthMiddleware(user, pwd string) *BasicAuthMiddleware {
-	return &BasicAuthMiddleware{
-		username: user,
-		password: pwd,
+			signed, err := signCookie(shm.ks, name, value)
+			if err == nil {
+				setCookies[i] = name + "=" + signed + attrs
+			}
+			break
+		}
 	}
 }
 
-func (bam *BasicAuthMiddleware) Before(r *http.Request, h *Handler) error {
-	uname, pwd, ok := r.BasicAuth()
-	if !ok {
-		return MiddlewareError{
-			StatusCode: http.StatusForbidden,
-			Err:        errors.New("basic auth required"),
+// replaceCookie swaps the value of a request cookie
+func replaceCookie(r *http.Request, name, value string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name == name {
+			c.Value = value
 		}
+		r.AddCookie(c)
 	}
+}
 
-	if bam.username != uname || bam.password != pwd {
-		return MiddlewareError{
-			StatusCode: http.StatusForbidden,
-			Err:        errors.New("invalid basic auth"),
-		}
+// a signingWriter signs headers just before they're written
+type signingWriter struct {
+	ResponseWriter
+	shm    *SignedHeadersMiddleware
+	signed bool
+}
+
+func (sw *signingWriter) sign() {
+	if !sw.signed {
+		sw.signed = true
+		sw.shm.signHeaders(sw.Header())
 	}
+}
 
-	return nil
+func (sw *signingWriter) WriteHeader(status int) {
+	sw.sign()
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *signingWriter) Write(b []byte) (int, error) {
+	sw.sign()
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *signingWriter) Flush() {
+	sw.sign()
+	sw.ResponseWriter.Flush()
 }
@@ -1,10 +1,13 @@
 package autoroute
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSignedHeaderMiddleware(t *testing.T) {
@@ -103,7 +106,332 @@ func TestBasicAuthMiddlewareInvalid(t *testing.T) {
 
 	handler.ServeHTTP(w, req)
 
-	if w.Result().StatusCode != http.StatusForbidden {
-		t.Error("request failed when it should have passed")
+	if w.Result().StatusCode != http.StatusUnauthorized {
+		t.Error("request passed when it should have failed")
+	}
+
+	if w.Header().Get("WWW-Authenticate") != `Basic realm="autoroute", charset="UTF-8"` {
+		t.Errorf("unexpected challenge %q", w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+// phaseMiddleware records every phase it sees
+type phaseMiddleware struct {
+	name   string
+	phases *[]string
+}
+
+func (pm *phaseMiddleware) Before(r *http.Request, h *Handler) error {
+	*pm.phases = append(*pm.phases, pm.name+" before")
+	return nil
+}
+
+func (pm *phaseMiddleware) Around(h *Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*pm.phases = append(*pm.phases, pm.name+" around")
+		w.Header().Set("X-"+pm.name, "yes")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (pm *phaseMiddleware) After(w ResponseWriter, r *http.Request, h *Handler) {
+	*pm.phases = append(*pm.phases, fmt.Sprintf("%s after %d %d", pm.name, w.Status(), w.Written()))
+}
+
+func TestResponseMiddleware(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	var phases []string
+	handler, err := NewHandler(ts.DoThingValueArgs, WithCodec(JSONCodec),
+		WithMiddleware(&phaseMiddleware{name: "outer", phases: &phases}),
+		WithMiddleware(&phaseMiddleware{name: "inner", phases: &phases}),
+		WithMiddleware(NewBasicAuthMiddleware("user", "user")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+	req.SetBasicAuth("user", "user")
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, req)
+
+	expected := []string{"outer around", "inner around", "outer before", "inner before", "inner after 200 16", "outer after 200 16"}
+	if !reflect.DeepEqual(phases, expected) {
+		t.Fatalf("expected %v, got %v", expected, phases)
+	}
+
+	if w.Header().Get("X-outer") != "yes" || w.Header().Get("X-inner") != "yes" {
+		t.Fatal("expected around middleware to set headers")
+	}
+
+	// after middleware sees rejections too
+	phases = nil
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, req)
+
+	if phases[len(phases)-1] != "outer after 401 32" {
+		t.Fatalf("expected a 401, got %v", phases)
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Parallel()
+
+	rw := newResponseWriter(httptest.NewRecorder())
+	if newResponseWriter(rw) != rw {
+		t.Fatal("expected an existing ResponseWriter to be reused")
+	}
+
+	_, _, err := rw.Hijack()
+	if err != http.ErrNotSupported {
+		t.Fatalf("expected hijacking a recorder to be unsupported, got %v", err)
+	}
+
+	rw.Flush()
+	if rw.Status() != http.StatusOK {
+		t.Fatalf("expected flushing to write a 200, got %d", rw.Status())
+	}
+}
+
+func TestSignedHeaderMiddlewareSigning(t *testing.T) {
+	t.Parallel()
+
+	shm := NewSignedHeadersMiddleware([]string{"x-api-key"}, "test-key")
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/login", func() *LoginOutput {
+		out := &LoginOutput{User: "ian"}
+		out.SetHeader("x-api-key", "hurray!")
+		out.SetCookie(&http.Cookie{Name: "x-api-key", Value: "hurray!", Path: "/", HttpOnly: true})
+		return out
+	}, WithMiddleware(shm.Signer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/special", func(hdr Header, c Cookies) TestOutput {
+		return TestOutput{Output: hdr.Get("x-api-key") + " " + c.Get("x-api-key")}
+	}, WithMiddleware(shm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	signed, err := shm.Sign("hurray!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Header().Get("x-api-key") != signed {
+		t.Fatalf("expected a signed header, got %q", w.Header().Get("x-api-key"))
+	}
+
+	// cookie values are signed along with the cookie's name
+	signedCookie, err := signCookie(shm.ks, "x-api-key", "hurray!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Header().Get("Set-Cookie") != "x-api-key="+signedCookie+"; Path=/; HttpOnly" {
+		t.Fatalf("expected a signed cookie, got %q", w.Header().Get("Set-Cookie"))
+	}
+
+	// the cookie works in place of the header
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/special", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "x-api-key", Value: signedCookie})
+	router.ServeHTTP(w, req)
+
+	diffJSON(t, `{"output":" hurray!"}`, w.Body.String())
+
+	// but a signature made for another cookie, or for the header, doesn't
+	otherCookie, err := signCookie(shm.ks, "session", "hurray!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{signed, otherCookie} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, "/special", nil)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "x-api-key", Value: value})
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected %q to be rejected, got %d", value, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/special", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "x-api-key", Value: "hurray!"})
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected an unsigned cookie to be rejected, got %d", w.Code)
+	}
+}
+
+// accessLogMiddleware logs the route pattern and status of every request
+type accessLogMiddleware struct {
+	lines []string
+}
+
+func (alm *accessLogMiddleware) Before(r *http.Request, h *Handler) error {
+	if r.Header.Get("x-blocked") != "" {
+		return MiddlewareError{StatusCode: http.StatusTeapot, Err: fmt.Errorf("blocked")}
+	}
+	return nil
+}
+
+func (alm *accessLogMiddleware) After(w ResponseWriter, r *http.Request, h *Handler) {
+	name := "-"
+	if h != nil {
+		name = h.Name()
+	}
+	alm.lines = append(alm.lines, fmt.Sprintf("%s %q %s %d", r.URL.Path, RoutePattern(r.Context()), name, w.Status()))
+}
+
+func TestRouterUse(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alm := &accessLogMiddleware{}
+	var phases []string
+	router.Use(alm, &phaseMiddleware{name: "global", phases: &phases})
+
+	err = router.Register(http.MethodPost, "/test", func(ti TestInput) TestOutput {
+		return TestOutput{Output: ti.Input}
+	}, WithName("echo"), WithMiddleware(&phaseMiddleware{name: "route", phases: &phases}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Mount("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static"))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/test", "/static/app.js", "/missing"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"input": "yo"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		if w.Header().Get("X-global") != "yes" {
+			t.Fatalf("expected %s to get the global header", path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-blocked", "yes")
+	router.ServeHTTP(w, req)
+
+	expected := []string{
+		`/test "/test" echo 200`,
+		`/static/app.js "/static/" - 200`,
+		`/missing "" - 404`,
+		`/test "/test" echo 418`,
+	}
+	if !reflect.DeepEqual(alm.lines, expected) {
+		t.Fatalf("expected %v, got %v", expected, alm.lines)
+	}
+
+	// router middleware runs before the route's own
+	expected = []string{"global around", "global before", "route around", "route before", "route after 200 16", "global after 200 16"}
+	if !reflect.DeepEqual(phases[:6], expected) {
+		t.Fatalf("expected %v, got %v", expected, phases[:6])
+	}
+}
+
+func TestSignedHeaderMiddlewareKeyring(t *testing.T) {
+	t.Parallel()
+
+	old := NewSignedHeadersMiddleware([]string{"x-api-key"}, "test-key")
+	oldSigned, err := old.Sign("is-this-signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := NewSignedHeadersMiddlewareWithKeyring([]string{"x-api-key"}, SigningKey{ID: "v1", Secret: "first-key"}, SigningKey{Secret: "test-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1Signed, err := v1.Sign("is-this-signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := NewSignedHeadersMiddlewareWithKeyring([]string{"x-api-key"}, SigningKey{ID: "v2", Secret: "second-key"},
+		SigningKey{ID: "v1", Secret: "first-key", RetiresAt: time.Now().Add(time.Hour)},
+		SigningKey{ID: "v0", Secret: "retired-key", RetiresAt: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v0, err := NewSignedHeadersMiddlewareWithKeyring([]string{"x-api-key"}, SigningKey{ID: "v0", Secret: "retired-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v0Signed, err := v0.Sign("is-this-signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name   string
+		shm    *SignedHeadersMiddleware
+		value  string
+		status int
+	}{
+		{"single key value after moving to a keyring", v1, oldSigned, http.StatusOK},
+		{"current key", v1, v1Signed, http.StatusOK},
+		{"previous key", v2, v1Signed, http.StatusOK},
+		{"retired key", v2, v0Signed, http.StatusForbidden},
+		{"dropped single key", v2, oldSigned, http.StatusForbidden},
+	}
+
+	for _, tc := range tcs {
+		handler, err := NewHandler((&TestServer{}).DoThingSignedMiddleware, WithCodec(JSONCodec), WithMiddleware(tc.shm))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+		req.Header.Set("x-api-key", tc.value)
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+	}
+
+	_, err = NewSignedHeadersMiddlewareWithKeyring([]string{"x-api-key"}, SigningKey{Secret: "no-id"})
+	if err == nil {
+		t.Fatal("expected an error for a current key without an ID")
 	}
 }
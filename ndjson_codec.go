@@ -0,0 +1,254 @@
+package autoroute
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrSendOnlyChannel = errors.New("autoroute: streamed input and output channels must be receive only (<-chan)")
+	ErrBadStreamItem   = errors.New("autoroute: streamed input channels must carry a struct or a pointer, like the body of JSONCodec")
+)
+
+// NDJSONCodec implements autoroute functionality for the mime type application/x-ndjson,
+// where a body is a stream of JSON values separated by newlines.
+// It supports the same function layouts as JSONCodec, except the body arg may be a
+// receive only channel, such as func(context.Context, <-chan *Item) error, which is fed
+// one decoded line at a time as the request body streams in, and the first output value
+// may also be a receive only channel, such as func(context.Context, *Query) (<-chan *Row, error),
+// whose values are written and flushed one line at a time until it is closed.
+// The context passed to the function is cancelled when the client goes away, when a
+// line of the body fails to decode, or once the response is complete, so functions
+// should stop sending and receiving when it's done. The rest of the body isn't read
+// once the function has returned, or its output channel is closed.
+// A line failing to decode once rows are being sent is reported by a final
+// {"error": "..."} line.
+// MaxSizeBytes applies to each line rather than the whole body.
+var NDJSONCodec Codec = ndjsonCodec{}
+
+type ndjsonCodec struct{}
+
+func (nc ndjsonCodec) Mime() string {
+	return "application/x-ndjson"
+}
+
+func (nc ndjsonCodec) ValidFn(fn reflect.Value) error {
+	err := validValueFn(fn)
+	if err != nil {
+		return err
+	}
+
+	fnType := fn.Type()
+	for i := 0; i < fnType.NumIn(); i++ {
+		in := fnType.In(i)
+		if in.Kind() != reflect.Chan {
+			continue
+		}
+
+		if in.ChanDir() != reflect.RecvDir {
+			return ErrSendOnlyChannel
+		}
+
+		// each line is decoded by JSONCodec, which only decodes these
+		if in.Elem().Kind() != reflect.Struct && in.Elem().Kind() != reflect.Ptr {
+			return ErrBadStreamItem
+		}
+	}
+
+	if fnType.NumOut() > 0 && fnType.Out(0).Kind() == reflect.Chan && fnType.Out(0).ChanDir() != reflect.RecvDir {
+		return ErrSendOnlyChannel
+	}
+
+	return nil
+}
+
+// an ndjsonStream decodes a streamed request body for a single request
+type ndjsonStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+func (ns *ndjsonStream) setErr(err error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if ns.err == nil {
+		ns.err = err
+	}
+	ns.cancel()
+}
+
+func (ns *ndjsonStream) Err() error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	return ns.err
+}
+
+// stop ends body decoding and waits on it, so nobody else touches the stream while we
+// still are, returning any error decoding hit until then
+func (ns *ndjsonStream) stop() error {
+	ns.cancel()
+	ns.wg.Wait()
+
+	return ns.Err()
+}
+
+func (ns *ndjsonStream) decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error) {
+	if inArg.Kind() != reflect.Chan {
+		// a plain value is a single line
+		return jsonCodec{}.decode(inArg, body, maxSizeBytes)
+	}
+
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, inArg.Elem()), 0)
+	done := reflect.ValueOf(ns.ctx.Done())
+
+	// the body is copied through a pipe, so decoding can stop while a stalled client
+	// keeps a read blocked. That read is left behind rather than waited on, and ends
+	// with the connection, as it's only ever written to the closed pipe.
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, body)
+		pw.CloseWithError(err)
+	}()
+
+	go func() {
+		<-ns.ctx.Done()
+		pr.CloseWithError(ns.ctx.Err())
+		// unblocks the read for HTTP/2, HTTP/1 bodies wait on it
+		body.Close()
+	}()
+
+	ns.wg.Add(1)
+	go func() {
+		defer ns.wg.Done()
+		defer ch.Close()
+
+		scanner := bufio.NewScanner(pr)
+		// a line is never allowed to grow past maxSizeBytes, not even the first 4k
+		bufSize := 4096
+		if maxSizeBytes < int64(bufSize) {
+			bufSize = int(maxSizeBytes)
+		}
+		scanner.Buffer(make([]byte, 0, bufSize), int(maxSizeBytes))
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			item, err := validatingDecoder(jsonCodec{}.decode)(inArg.Elem(), ioutil.NopCloser(bytes.NewReader(line)), maxSizeBytes)
+			if err != nil {
+				ns.setErr(err)
+				return
+			}
+
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: ch, Send: item},
+				{Dir: reflect.SelectRecv, Chan: done},
+			})
+			if chosen == 1 {
+				return
+			}
+		}
+
+		// the pipe's error once we stopped decoding isn't the body's fault
+		if err := scanner.Err(); err != nil && err != ns.ctx.Err() {
+			ns.setErr(err)
+		}
+	}()
+
+	return ch, nil
+}
+
+func (nc ndjsonCodec) HandleRequest(cra *CodecRequestArgs) {
+	ctx, cancel := context.WithCancel(cra.Request.Context())
+	stream := &ndjsonStream{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	defer stream.stop()
+
+	cra.Request = cra.Request.WithContext(ctx)
+	callArgs, err := buildCallArgs(cra, stream.decode)
+	if err != nil {
+		writeCallArgsError(cra.ResponseWriter, cra.ErrorHandler, err)
+		return
+	}
+
+	outputValues := cra.HandlerFn.Call(callArgs)
+	cra.ResponseWriter.Header().Set("Content-Type", nc.Mime())
+
+	streaming := cra.OutputArgCount > 0 && outputValues[0].Kind() == reflect.Chan
+	err = stream.Err()
+	if !streaming {
+		// the function is done with the body, but a line it never saw may have failed
+		err = stream.stop()
+	}
+
+	// a broken input stream trumps whatever the function made of it
+	if err != nil {
+		cra.ErrorHandler.Handle(cra.ResponseWriter, reflect.ValueOf(err))
+		return
+	}
+
+	if !streaming {
+		writeOutputs(cra, outputValues, nc.encode)
+		return
+	}
+
+	if cra.OutputArgCount == 2 && !outputValues[1].IsNil() {
+		cra.ErrorHandler.Handle(cra.ResponseWriter, outputValues[1])
+		return
+	}
+
+	cra.ResponseWriter.WriteHeader(http.StatusOK)
+	if outputValues[0].IsNil() {
+		return
+	}
+
+	flusher, _ := cra.ResponseWriter.(http.Flusher)
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: outputValues[0]},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+
+	for {
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 || !ok {
+			break
+		}
+
+		err := nc.encode(cra.ResponseWriter, item.Interface())
+		if err != nil {
+			// there's no one left to report this to
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	// the status is long gone, so a broken input stream gets the last line
+	if err := stream.stop(); err != nil {
+		nc.encode(cra.ResponseWriter, map[string]string{"error": err.Error()})
+	}
+}
+
+func (nc ndjsonCodec) encode(w io.Writer, v interface{}) error {
+	// json.Encoder terminates every value with a newline
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,283 @@
+package autoroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestRow struct {
+	N int `json:"n"`
+}
+
+func (t *TestServer) DoThingConsumeStream(ctx context.Context, inputs <-chan *TestInput) (*TestOutput, error) {
+	var seen []string
+	for ti := range inputs {
+		seen = append(seen, ti.Input)
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return &TestOutput{
+		Output: strings.Join(seen, ","),
+	}, nil
+}
+
+func (t *TestServer) DoThingProduceStream(ctx context.Context, ti *TestInput) (<-chan *TestRow, error) {
+	if ti.Input == "fail" {
+		return nil, errors.New("sup")
+	}
+
+	rows := make(chan *TestRow)
+	go func() {
+		defer close(rows)
+		for i := 0; i < 3; i++ {
+			select {
+			case rows <- &TestRow{N: i}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return rows, nil
+}
+
+func TestNDJSONHandlerInputStream(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingConsumeStream, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("{\"input\": \"a\"}\n\n{\"input\": \"b\"}\n{\"input\": \"c\"}"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"output":"a,b,c"}`, w.Body.String())
+}
+
+func TestNDJSONHandlerInputStreamDecodeError(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingConsumeStream, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("{\"input\": \"a\"}\n{\"unknown\": 1}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"error":"json: unknown field \"unknown\""}`, w.Body.String())
+}
+
+func TestNDJSONHandlerOutputStream(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingProduceStream, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	if w.Body.String() != "{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	if !w.Flushed {
+		t.Fatal("did not flush streamed rows")
+	}
+}
+
+func TestNDJSONHandlerOutputStreamError(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingProduceStream, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "fail"}`))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"error":"sup"}`, w.Body.String())
+}
+
+func TestNDJSONHandlerClientGone(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingProduceStream, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	if strings.Count(w.Body.String(), "\n") == 3 {
+		t.Fatal("kept streaming to a client that went away")
+	}
+}
+
+func TestNDJSONHandlerSendOnlyChannel(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewHandler(func(ctx context.Context, in chan<- *TestInput) {}, WithCodec(NDJSONCodec))
+	if err != ErrSendOnlyChannel {
+		t.Fatalf("expected ErrSendOnlyChannel, got %v", err)
+	}
+}
+
+func TestNDJSONHandlerBadStreamItem(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewHandler(func(ctx context.Context, in <-chan int) {}, WithCodec(NDJSONCodec))
+	if err != ErrBadStreamItem {
+		t.Fatalf("expected ErrBadStreamItem, got %v", err)
+	}
+}
+
+func TestNDJSONHandlerInputStreamLineTooLong(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingConsumeStream, WithCodec(NDJSONCodec), WithMaxSizeBytes(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("{\"input\": \"a\"}\n{\"input\": \"this is too long\"}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"error":"bufio.Scanner: token too long"}`, w.Body.String())
+}
+
+func TestNDJSONHandlerDecodeErrorAfterReturn(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(ctx context.Context, inputs <-chan *TestInput) *TestOutput {
+		ti := <-inputs
+		return &TestOutput{Output: ti.Input}
+	}, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("{\"input\": \"a\"}\n{\"unknown\": 1}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"error":"json: unknown field \"unknown\""}`, w.Body.String())
+}
+
+func TestNDJSONHandlerDecodeErrorWhileStreaming(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(ctx context.Context, inputs <-chan *TestInput) <-chan *TestOutput {
+		outputs := make(chan *TestOutput)
+		go func() {
+			defer close(outputs)
+			for ti := range inputs {
+				select {
+				case outputs <- &TestOutput{Output: ti.Input}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return outputs
+	}, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("{\"input\": \"a\"}\n{\"unknown\": 1}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	handler.ServeHTTP(w, req)
+
+	if !strings.HasSuffix(w.Body.String(), "{\"error\":\"json: unknown field \\\"unknown\\\"\"}\n") {
+		t.Fatalf("expected a final error line, got %q", w.Body.String())
+	}
+}
+
+func TestNDJSONHandlerStalledClient(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(ctx context.Context, inputs <-chan *TestInput) *TestOutput {
+		ti := <-inputs
+		return &TestOutput{Output: ti.Input}
+	}, WithCodec(NDJSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	returned := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		close(returned)
+	}))
+	defer srv.Close()
+
+	// a chunked body that sends a line and then never finishes, over a raw connection
+	// as http.Client waits to finish sending a body
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line := "{\"input\": \"a\"}\n"
+	_, err = fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: application/x-ndjson\r\nTransfer-Encoding: chunked\r\n\r\n%x\r\n%s\r\n", len(line), line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// net/http itself still waits on the body before responding over HTTP/1, but the
+	// handler shouldn't
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler waited on a stalled body")
+	}
+}
@@ -0,0 +1,328 @@
+package autoroute
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited = errors.New("autoroute: rate limit exceeded")
+)
+
+// the algorithms a RateLimit can use
+const (
+	TokenBucketAlgorithm = iota
+	SlidingWindowAlgorithm
+)
+
+// A RateLimit allows Requests per Window to each key
+type RateLimit struct {
+	Algorithm int
+	Requests  int
+	Window    time.Duration
+}
+
+// TokenBucket allows bursts of up to requests at once, refilling at requests per window
+func TokenBucket(requests int, window time.Duration) RateLimit {
+	return RateLimit{Algorithm: TokenBucketAlgorithm, Requests: requests, Window: window}
+}
+
+// SlidingWindow allows requests in any window long span of time, weighting the count
+// of the previous fixed window by how much of it the span still covers
+func SlidingWindow(requests int, window time.Duration) RateLimit {
+	return RateLimit{Algorithm: SlidingWindowAlgorithm, Requests: requests, Window: window}
+}
+
+// RateLimitResult is the outcome of taking a request from a key's limit
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the full limit is available again
+	Reset time.Duration
+	// RetryAfter is how long until a refused request would be allowed
+	RetryAfter time.Duration
+}
+
+// A RateLimitStore keeps the state of every key's limit. Take has to count the request
+// and decide on it atomically, as it's called concurrently.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc picks the key a request is limited by. Requests with an empty key
+// aren't limited.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitByIP keys requests by the IP address of the client connection. Behind a
+// proxy that's the proxy's address, so use a key func trusting its headers instead.
+func RateLimitByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// RateLimitByHeader keys requests by the value of a header, such as an x-api-key
+// verified by SignedHeadersMiddleware earlier in the chain, falling back to the
+// client IP when it's missing
+func RateLimitByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return name + "=" + v
+		}
+
+		return RateLimitByIP(r)
+	}
+}
+
+type RateLimitOption func(rlm *RateLimitMiddleware)
+
+// WithRateLimitKey sets how requests are keyed, by RateLimitByIP by default
+func WithRateLimitKey(fn RateLimitKeyFunc) RateLimitOption {
+	return func(rlm *RateLimitMiddleware) {
+		rlm.key = fn
+	}
+}
+
+// WithRateLimitStore keeps limits in store rather than in memory, prefixing every key
+// with prefix so middlewares can share it
+func WithRateLimitStore(store RateLimitStore, prefix string) RateLimitOption {
+	return func(rlm *RateLimitMiddleware) {
+		rlm.store = store
+		rlm.prefix = prefix
+	}
+}
+
+// RateLimitMiddleware refuses requests beyond a RateLimit with a 429, sending
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers on every response
+// and a Retry-After when refused. Give each route, or group of routes, its own
+// middleware with WithMiddleware, or limit every request with Router.Use.
+type RateLimitMiddleware struct {
+	limit  RateLimit
+	key    RateLimitKeyFunc
+	store  RateLimitStore
+	prefix string
+
+	now func() time.Time
+}
+
+func NewRateLimitMiddleware(limit RateLimit, opts ...RateLimitOption) *RateLimitMiddleware {
+	rlm := &RateLimitMiddleware{
+		limit: limit,
+		key:   RateLimitByIP,
+		store: NewMemoryRateLimitStore(),
+		now:   time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(rlm)
+	}
+
+	return rlm
+}
+
+// rateLimitWriterKey holds the response writer Before sets headers on
+type rateLimitWriterKey struct {
+	rlm *RateLimitMiddleware
+}
+
+// Around lets Before reach the response, to send the RateLimit headers of allowed requests
+func (rlm *RateLimitMiddleware) Around(h *Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitWriterKey{rlm}, w)))
+	})
+}
+
+func (rlm *RateLimitMiddleware) Before(r *http.Request, h *Handler) error {
+	key := rlm.key(r)
+	if key == "" {
+		return nil
+	}
+
+	result, err := rlm.store.Take(r.Context(), rlm.prefix+key, rlm.limit, rlm.now())
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		return MiddlewareError{
+			StatusCode: http.StatusTooManyRequests,
+			Err:        ErrRateLimited,
+			Header:     header,
+		}
+	}
+
+	if w, ok := r.Context().Value(rateLimitWriterKey{rlm}).(http.ResponseWriter); ok {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+	}
+
+	return nil
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+const rateLimitShards = 32
+
+// MemoryRateLimitStore is a RateLimitStore for a single process, spreading keys over
+// shards so concurrent requests rarely wait on each other
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	sync.Mutex
+	buckets   map[string]*rateLimitState
+	lastSweep time.Time
+}
+
+// rateLimitState is a token bucket, or the counts of a sliding window
+type rateLimitState struct {
+	tokens float64
+
+	windowStart     time.Time
+	count, previous int
+
+	last    time.Time
+	expires time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].buckets = make(map[string]*rateLimitState)
+	}
+
+	return s
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	if limit.Requests <= 0 || limit.Window <= 0 {
+		return RateLimitResult{}, errors.New("autoroute: a rate limit needs a positive number of requests and window")
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	shard := &s.shards[hash.Sum32()%rateLimitShards]
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	shard.sweep(now, limit.Window)
+
+	state, ok := shard.buckets[key]
+	if !ok {
+		state = &rateLimitState{tokens: float64(limit.Requests), last: now, windowStart: now.Truncate(limit.Window)}
+		shard.buckets[key] = state
+	}
+
+	var result RateLimitResult
+	if limit.Algorithm == SlidingWindowAlgorithm {
+		result = state.slidingWindow(limit, now)
+	} else {
+		result = state.tokenBucket(limit, now)
+	}
+
+	state.expires = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops the state of keys whose limits have fully reset, at most once a window
+func (shard *rateLimitShard) sweep(now time.Time, window time.Duration) {
+	if now.Sub(shard.lastSweep) < window {
+		return
+	}
+	shard.lastSweep = now
+
+	for key, state := range shard.buckets {
+		if !now.Before(state.expires) {
+			delete(shard.buckets, key)
+		}
+	}
+}
+
+func (state *rateLimitState) tokenBucket(limit RateLimit, now time.Time) RateLimitResult {
+	capacity := float64(limit.Requests)
+	perToken := limit.Window / time.Duration(limit.Requests)
+
+	if elapsed := now.Sub(state.last); elapsed > 0 {
+		state.tokens = math.Min(capacity, state.tokens+float64(elapsed)/float64(perToken))
+	}
+	state.last = now
+
+	result := RateLimitResult{Limit: limit.Requests}
+	if state.tokens >= 1 {
+		state.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - state.tokens) * float64(perToken))
+	}
+
+	result.Remaining = int(state.tokens)
+	result.Reset = time.Duration((capacity - state.tokens) * float64(perToken))
+	return result
+}
+
+func (state *rateLimitState) slidingWindow(limit RateLimit, now time.Time) RateLimitResult {
+	start := now.Truncate(limit.Window)
+	switch {
+	case start.Equal(state.windowStart):
+	case start.Sub(state.windowStart) == limit.Window:
+		state.previous, state.count = state.count, 0
+	default:
+		state.previous, state.count = 0, 0
+	}
+	state.windowStart = start
+
+	// the share of the previous window the sliding window still covers
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	estimate := float64(state.previous)*weight + float64(state.count)
+
+	result := RateLimitResult{Limit: limit.Requests}
+	if estimate+1 <= float64(limit.Requests) {
+		state.count++
+		estimate++
+		result.Allowed = true
+	} else if state.count < limit.Requests {
+		// wait until enough of the previous window has slid out
+		need := 1 - float64(limit.Requests-state.count-1)/float64(state.previous)
+		result.RetryAfter = time.Duration(need*float64(limit.Window)) - elapsed
+	} else {
+		// the current window is full alone, so it has to start sliding out too
+		need := 1 - float64(limit.Requests-1)/float64(state.count)
+		result.RetryAfter = limit.Window - elapsed + time.Duration(need*float64(limit.Window))
+	}
+
+	result.Remaining = limit.Requests - int(math.Ceil(estimate))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	// everything counted so far has slid out by the end of the next window
+	result.Reset = 2*limit.Window - elapsed
+	if state.count == 0 {
+		result.Reset = limit.Window - elapsed
+	}
+
+	return result
+}
@@ -0,0 +1,136 @@
+package autoroute
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rlm := NewRateLimitMiddleware(TokenBucket(2, time.Minute), WithRateLimitKey(RateLimitByHeader("x-api-key")))
+	rlm.now = func() time.Time { return now }
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/test", func(ti TestInput) TestOutput {
+		return TestOutput{Output: ti.Input}
+	}, WithMiddleware(rlm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+		req.Header.Set(MimeTypeHeader, "application/json")
+		req.Header.Set("x-api-key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("a")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" || w.Header().Get("RateLimit-Reset") != "30" {
+		t.Fatalf("expected an allowed request with headers, got %d %v", w.Code, w.Header())
+	}
+
+	send("a")
+	w = send("a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected a 429 with a Retry-After, got %d %v", w.Code, w.Header())
+	}
+
+	diffJSON(t, `{"error":"autoroute: rate limit exceeded"}`, w.Body.String())
+
+	// other keys have their own buckets
+	if w = send("b"); w.Code != http.StatusOK {
+		t.Fatalf("expected another key to be allowed, got %d", w.Code)
+	}
+
+	now = now.Add(30 * time.Second)
+	if w = send("a"); w.Code != http.StatusOK {
+		t.Fatalf("expected a refilled token, got %d", w.Code)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryRateLimitStore()
+	limit := SlidingWindow(4, time.Minute)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	take := func(offset time.Duration) RateLimitResult {
+		result, err := store.Take(context.Background(), "key", limit, start.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	for i := 0; i < 4; i++ {
+		if !take(50 * time.Second).Allowed {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+
+	result := take(55 * time.Second)
+	if result.Allowed || result.RetryAfter != 20*time.Second {
+		t.Fatalf("expected a full window to wait for the next one to slide, got %+v", result)
+	}
+
+	// 14s into the next window, over three quarters of the last one still counts
+	result = take(74 * time.Second)
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Fatalf("expected to wait for a quarter of the window to slide, got %+v", result)
+	}
+
+	result = take(75 * time.Second)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected the window to have slid enough, got %+v", result)
+	}
+
+	// long after, everything has slid out
+	result = take(10 * time.Minute)
+	if !result.Allowed || result.Remaining != 3 {
+		t.Fatalf("expected a fresh window, got %+v", result)
+	}
+}
+
+func TestMemoryRateLimitStoreConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryRateLimitStore()
+	limit := TokenBucket(50, time.Hour)
+	now := time.Now()
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := store.Take(context.Background(), "key", limit, now)
+			if err == nil && result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 50 {
+		t.Fatalf("expected exactly 50 requests allowed, got %d", allowed)
+	}
+}
@@ -0,0 +1,95 @@
+package autoroute
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// A ResponseWriter is the http.ResponseWriter response phase middleware sees, which
+// remembers what was written. It still implements http.Flusher and http.Hijacker,
+// failing with http.ErrNotSupported when the wrapped writer doesn't.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+
+	// Status is the status code written, or zero when nothing has been written yet
+	Status() int
+	// Written is the number of body bytes written
+	Written() int64
+	// Unwrap returns the wrapped http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+type statusWriter struct {
+	w       http.ResponseWriter
+	status  int
+	written int64
+}
+
+// newResponseWriter wraps w, unless it's already one of ours
+func newResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+
+	return &statusWriter{w: w}
+}
+
+func (sw *statusWriter) Header() http.Header {
+	return sw.w.Header()
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+
+	sw.w.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	n, err := sw.w.Write(b)
+	sw.written += int64(n)
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		sw.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, err
+}
+
+func (sw *statusWriter) Status() int {
+	return sw.status
+}
+
+func (sw *statusWriter) Written() int64 {
+	return sw.written
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.w
+}
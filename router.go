@@ -2,21 +2,36 @@ package autoroute
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"net/http"
+	"strings"
 )
 
 var (
 	ErrAlreadyRegistered = errors.New("autoroute: route already registered")
 	ErrInvalidMethod     = errors.New("autoroute: not a valid method")
+
+	errMethodNotFound  = errors.New("method not found")
+	errAmbiguousMethod = errors.New("ambiguous method, more than one handler has this name")
 )
 
 // Router implements an autoroute aware grouping of autoroute.Handler's
 type Router struct {
 	// map[http.Method]map[Path]*Handler
 	routeMap map[string]map[string]*Handler
+	// every registered handler, in the order they were registered
+	handlers []*Handler
+
+	// map[Path]http.Handler, paths ending in a slash match everything below them
+	mounts map[string]http.Handler
+
+	// the stores of async handlers, whose jobs are served below JobsPath
+	jobStores []JobStore
 
 	defaultHandlerOptions []HandlerOption
+	// set by Use, run for every request
+	middlewares []Middleware
 
 	defaultErrorHandler ErrorHandler
 	NotFoundHandler     http.Handler
@@ -32,6 +47,7 @@ func NewRouter(handlerOptions ...HandlerOption) (*Router, error) {
 
 	return &Router{
 		routeMap:              defaultRouteMap,
+		mounts:                make(map[string]http.Handler),
 		defaultHandlerOptions: handlerOptions,
 		defaultErrorHandler:   DefaultErrorHandler,
 		NotFoundHandler:       http.NotFoundHandler(),
@@ -44,6 +60,9 @@ func (ro *Router) Register(method string, path string, x interface{}, extraOptio
 	}
 
 	defaultOptions = append(defaultOptions, ro.defaultHandlerOptions...)
+	defaultOptions = append(defaultOptions, func(h *Handler) {
+		h.routerMiddlewares = len(h.middlewares)
+	})
 	defaultOptions = append(defaultOptions, extraOptions...)
 	h, err := NewHandler(x, defaultOptions...)
 	if err != nil {
@@ -61,11 +80,133 @@ func (ro *Router) Register(method string, path string, x interface{}, extraOptio
 		return ErrAlreadyRegistered
 	}
 
+	if h.async != nil {
+		h.async.route = method + " " + path
+		err = ro.addJobStore(h.async.store)
+		if err != nil {
+			return err
+		}
+	}
+
 	ro.routeMap[method][path] = h
+	ro.handlers = append(ro.handlers, h)
+
+	return nil
+}
+
+// Mount serves a plain http.Handler at path for every method. A path ending in a slash
+// also serves everything below it, unless a more specific route matches.
+func (ro *Router) Mount(path string, h http.Handler) error {
+	_, ok := ro.mounts[path]
+	if ok {
+		return ErrAlreadyRegistered
+	}
+
+	ro.mounts[path] = h
+
+	return nil
+}
+
+// Use adds middleware run for every request before it's dispatched, including mounted
+// handlers and requests matching no route, which makes it the place for access logs
+// and security headers. The h passed to middleware is the matched Handler, or nil for
+// mounted handlers and unknown routes, and RoutePattern tells them what matched.
+// Middleware given to a Handler with WithMiddleware still runs after these.
+func (ro *Router) Use(middlewares ...Middleware) {
+	ro.middlewares = append(ro.middlewares, middlewares...)
+}
+
+// Close closes every registered handler, stopping the workers of async handlers
+func (ro *Router) Close() error {
+	for _, h := range ro.handlers {
+		h.Close()
+	}
+
+	return nil
+}
+
+type routePatternKey struct{}
+
+// RoutePattern returns the pattern of the route a request matched: the path it was
+// registered or mounted at, which for a mount ending in a slash may be a prefix of
+// the request path. It's empty when no route matched.
+func RoutePattern(ctx context.Context) string {
+	pattern, _ := ctx.Value(routePatternKey{}).(string)
+	return pattern
+}
+
+// addJobStore serves the jobs of store, mounting the jobs handler the first time
+func (ro *Router) addJobStore(store JobStore) error {
+	for _, js := range ro.jobStores {
+		if js == store {
+			return nil
+		}
+	}
+
+	if len(ro.jobStores) == 0 {
+		err := ro.Mount(JobsPath, &jobsHandler{router: ro})
+		if err != nil {
+			return err
+		}
+	}
+
+	ro.jobStores = append(ro.jobStores, store)
 
 	return nil
 }
 
+// mounted finds the mounted handler for path, preferring an exact match and then the
+// longest matching prefix, returning the path it was mounted at
+func (ro *Router) mounted(path string) (http.Handler, string, bool) {
+	h, ok := ro.mounts[path]
+	if ok {
+		return h, path, true
+	}
+
+	var longest string
+	for prefix, ph := range ro.mounts {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+			h = ph
+		}
+	}
+
+	return h, longest, longest != ""
+}
+
+// handlerByName finds the one registered handler with the given Name. Names aren't
+// unique, so it's an error when several handlers share it.
+func (ro *Router) handlerByName(name string) (*Handler, error) {
+	var found *Handler
+	for _, h := range ro.handlers {
+		if h.Name() != name {
+			continue
+		}
+
+		if found != nil {
+			return nil, errAmbiguousMethod
+		}
+		found = h
+	}
+
+	if found == nil {
+		return nil, errMethodNotFound
+	}
+
+	return found, nil
+}
+
+// handlerByRoute finds the handler registered at a route of the form "POST /path"
+func (ro *Router) handlerByRoute(route string) (*Handler, bool) {
+	i := strings.Index(route, " ")
+	if i < 0 {
+		return nil, false
+	}
+
+	h, ok := ro.routeMap[route[:i]][route[i+1:]]
+	return h, ok
+}
+
 func methodAllowed(method string) bool {
 	return method == http.MethodDelete ||
 		method == http.MethodGet ||
@@ -74,24 +215,43 @@ func methodAllowed(method string) bool {
 		method == http.MethodPut
 }
 
-func (ro *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// match finds the handler for a request and the pattern it matched, or the
+// NotFoundHandler and no pattern
+func (ro *Router) match(r *http.Request) (http.Handler, string) {
 	routesForMethod := ro.routeMap[r.Method]
-	handler, ok := routesForMethod[r.URL.Path]
-	if !ok {
-		ro.NotFoundHandler.ServeHTTP(w, r)
-
-		if r.Body == nil || r.Body == http.NoBody {
-			// do nothing
-		} else {
-			// chew up the rest of the body
-			var buf bytes.Buffer
-			buf.ReadFrom(r.Body)
-			r.Body.Close()
-		}
-		return
+	routeHandler, ok := routesForMethod[r.URL.Path]
+	if ok {
+		return routeHandler, r.URL.Path
 	}
 
-	handler.ServeHTTP(w, r)
+	handler, pattern, ok := ro.mounted(r.URL.Path)
+	if ok {
+		return handler, pattern
+	}
+
+	return ro.NotFoundHandler, ""
+}
+
+func (ro *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := ro.match(r)
+	r = r.WithContext(context.WithValue(r.Context(), routePatternKey{}, pattern))
+
+	if len(ro.middlewares) == 0 {
+		handler.ServeHTTP(w, r)
+	} else {
+		// middleware only sees autoroute handlers
+		h, _ := handler.(*Handler)
+		serveMiddleware(w, r, h, ro.middlewares, func(w http.ResponseWriter, r *http.Request) {
+			r, err := h.before(r, ro.middlewares)
+			if err != nil {
+				writeMiddlewareError(w, ro.defaultErrorHandler, err)
+				return
+			}
+
+			recordServed(r)
+			handler.ServeHTTP(w, r)
+		})
+	}
 
 	if r.Body == nil || r.Body == http.NoBody {
 		// do nothing
@@ -0,0 +1,266 @@
+package autoroute
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// LastEventID is the Last-Event-ID a reconnecting EventSource sent, or empty on its
+// first connection. It's injected like Header, so functions can take it alongside a body arg.
+type LastEventID string
+
+var lastEventIDType = reflect.TypeOf(LastEventID(""))
+
+// An Event wraps a value sent down an event stream with its optional event name,
+// id and reconnection delay
+type Event struct {
+	ID    string
+	Name  string
+	Retry time.Duration
+	Data  interface{}
+}
+
+// An EventStream is a source of server-sent events, for functions that would
+// rather not use a channel
+type EventStream interface {
+	// Next blocks until the next value is ready or ctx is done. It returns io.EOF
+	// once the stream is finished.
+	Next(ctx context.Context) (interface{}, error)
+}
+
+var eventStreamType = reflect.TypeOf((*EventStream)(nil)).Elem()
+
+// SSECodec implements autoroute functionality for the mime type text/event-stream.
+// Functions have the same layouts as with JSONCodec, except the first output value can
+// be a receive only channel or an EventStream. Every value received from it is JSON encoded into the data field of
+// an event, unless it's an Event (or *Event), which also sets the name and id.
+// An error from an EventStream is sent as a final "error" event.
+// Functions returning anything else send a single event.
+// As EventSource requests carry no Content-Type, SSECodec is usually picked by their
+// Accept header, and the context passed to the function is cancelled when the client
+// goes away. A keep-alive comment is sent every 15 seconds, use NewSSECodec to change that.
+var SSECodec Codec = NewSSECodec()
+
+type SSEOption func(sc *sseCodec)
+
+// WithSSEKeepAlive sets how often a comment is sent on an idle stream to keep
+// proxies from closing it. Zero disables keep-alives.
+func WithSSEKeepAlive(interval time.Duration) SSEOption {
+	return func(sc *sseCodec) {
+		sc.keepAlive = interval
+	}
+}
+
+// NewSSECodec creates a Codec for text/event-stream
+func NewSSECodec(opts ...SSEOption) Codec {
+	sc := sseCodec{
+		keepAlive: 15 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(&sc)
+	}
+
+	return sc
+}
+
+type sseCodec struct {
+	keepAlive time.Duration
+}
+
+func (sc sseCodec) Mime() string {
+	return "text/event-stream"
+}
+
+func (sc sseCodec) ValidFn(fn reflect.Value) error {
+	err := validValueFn(fn)
+	if err != nil {
+		return err
+	}
+
+	fnType := fn.Type()
+	if fnType.NumOut() > 0 && fnType.Out(0).Kind() == reflect.Chan && fnType.Out(0).ChanDir() != reflect.RecvDir {
+		return ErrSendOnlyChannel
+	}
+
+	return nil
+}
+
+func (sc sseCodec) HandleRequest(cra *CodecRequestArgs) {
+	ctx, cancel := context.WithCancel(cra.Request.Context())
+	defer cancel()
+
+	cra.Request = cra.Request.WithContext(ctx)
+	callArgs, err := buildCallArgs(cra, func(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error) {
+		if cra.Request.ContentLength == 0 {
+			// the usual case, decode an empty object
+			body = nil
+		}
+
+		return jsonCodec{}.decode(inArg, body, maxSizeBytes)
+	})
+	if err != nil {
+		writeCallArgsError(cra.ResponseWriter, cra.ErrorHandler, err)
+		return
+	}
+
+	outputValues := cra.HandlerFn.Call(callArgs)
+	if cra.OutputArgCount == 0 {
+		cra.ResponseWriter.WriteHeader(http.StatusOK)
+		return
+	}
+
+	last := outputValues[cra.OutputArgCount-1]
+	if last.Kind() == reflect.Interface && last.Type().ConvertibleTo(errorType) && !last.IsNil() {
+		cra.ErrorHandler.Handle(cra.ResponseWriter, last)
+		return
+	}
+
+	w := cra.ResponseWriter
+	w.Header().Set("Content-Type", sc.Mime())
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	out := outputValues[0]
+	var events reflect.Value
+	var streamErr *error
+	switch {
+	case out.Kind() == reflect.Chan:
+		if out.IsNil() {
+			return
+		}
+		events = out
+	case out.Type().Implements(eventStreamType) && !(out.Kind() == reflect.Interface && out.IsNil()):
+		events, streamErr = pumpEventStream(ctx, out.Interface().(EventStream))
+	case out.Type().ConvertibleTo(errorType):
+		// func() error returning nil, nothing to send
+		return
+	default:
+		writeEvent(w, out.Interface())
+		flush()
+		return
+	}
+
+	var keepAlive reflect.Value
+	if sc.keepAlive > 0 {
+		ticker := time.NewTicker(sc.keepAlive)
+		defer ticker.Stop()
+		keepAlive = reflect.ValueOf(ticker.C)
+	}
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: events},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: keepAlive},
+	}
+
+	// let the client know we're connected straight away
+	flush()
+	for {
+		chosen, item, ok := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			if !ok {
+				if streamErr != nil && *streamErr != nil {
+					writeEvent(w, Event{Name: "error", Data: map[string]interface{}{"error": (*streamErr).Error()}})
+					flush()
+				}
+				return
+			}
+
+			err := writeEvent(w, item.Interface())
+			if err != nil {
+				return
+			}
+		case 1:
+			return
+		case 2:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		}
+
+		flush()
+	}
+}
+
+// pumpEventStream turns an EventStream into a channel, closed once the stream ends
+// or ctx is done. Any error other than io.EOF is stored in the returned error
+// before the channel is closed.
+func pumpEventStream(ctx context.Context, es EventStream) (reflect.Value, *error) {
+	ch := make(chan interface{})
+	var streamErr error
+
+	go func() {
+		defer close(ch)
+		for {
+			v, err := es.Next(ctx)
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					streamErr = err
+				}
+				return
+			}
+
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return reflect.ValueOf(ch), &streamErr
+}
+
+var sseFieldSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+// writeEvent writes a single event frame
+func writeEvent(w io.Writer, v interface{}) error {
+	var ev Event
+	switch e := v.(type) {
+	case Event:
+		ev = e
+	case *Event:
+		if e != nil {
+			ev = *e
+		}
+	default:
+		ev.Data = v
+	}
+
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", sseFieldSanitizer.Replace(ev.ID))
+	}
+	if ev.Name != "" {
+		fmt.Fprintf(&buf, "event: %s\n", sseFieldSanitizer.Replace(ev.Name))
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	fmt.Fprintf(&buf, "data: %s\n\n", data)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
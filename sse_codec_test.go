@@ -0,0 +1,158 @@
+package autoroute
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (t *TestServer) DoThingEvents(ctx context.Context, lastID LastEventID) (<-chan Event, error) {
+	t.input = string(lastID)
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		for _, id := range []string{"1", "2"} {
+			select {
+			case events <- Event{ID: id, Name: "tick", Data: TestOutput{Output: "hi"}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+type testEventStream struct {
+	sent int
+}
+
+func (tes *testEventStream) Next(ctx context.Context) (interface{}, error) {
+	if tes.sent == 1 {
+		return nil, errors.New("sup")
+	}
+
+	tes.sent++
+	return TestOutput{Output: "hi"}, nil
+}
+
+func TestSSEHandlerChannel(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThingEvents, WithCodec(SSECodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "0")
+
+	handler.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+
+	expected := "id: 1\nevent: tick\ndata: {\"output\":\"hi\"}\n\n" +
+		"id: 2\nevent: tick\ndata: {\"output\":\"hi\"}\n\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	if ts.input != "0" {
+		t.Fatalf("did not pass Last-Event-ID, got %q", ts.input)
+	}
+}
+
+func TestSSEHandlerEventStream(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func() EventStream {
+		return &testEventStream{}
+	}, WithCodec(SSECodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	handler.ServeHTTP(w, req)
+
+	expected := "data: {\"output\":\"hi\"}\n\n" +
+		"event: error\ndata: {\"error\":\"sup\"}\n\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestSSEHandlerKeepAliveAndDisconnect(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(ctx context.Context) <-chan int {
+		// never sends anything
+		return make(chan int)
+	}, WithCodec(NewSSECodec(WithSSEKeepAlive(5*time.Millisecond))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, len(": keep-alive\n\n"))
+	_, err = io.ReadFull(resp.Body, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(buf), ": keep-alive") {
+		t.Fatalf("expected a keep-alive comment, got %q", buf)
+	}
+}
+
+func TestLastEventIDWithBody(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(lastID LastEventID, ti TestInput) TestOutput {
+		return TestOutput{Output: ti.Input + " after " + string(lastID)}
+	}, WithCodec(JSONCodec), WithCodec(SSECodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"input": "yo"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Last-Event-ID", "7")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"output":"yo after 7"}`, w.Body.String())
+}
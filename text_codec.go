@@ -0,0 +1,126 @@
+package autoroute
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+var (
+	ErrNotText = errors.New("autoroute: text/plain bodies can only be decoded into a string, []byte or encoding.TextUnmarshaler")
+)
+
+// TextCodec implements autoroute functionality for the mime type text/plain, and supports
+// the same function layouts as JSONCodec.
+// The body arg can be a string, a []byte or anything implementing encoding.TextUnmarshaler,
+// and receives the whole request body. Output values are written using encoding.TextMarshaler,
+// fmt.Stringer, or as is for strings and []byte, falling back to fmt.Print, and slices
+// are written one element per line, so func(string) []string is a fine text handler.
+var TextCodec Codec = textCodec{}
+
+type textCodec struct{}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func (tc textCodec) Mime() string {
+	return "text/plain"
+}
+
+func (tc textCodec) ValidFn(fn reflect.Value) error {
+	return validValueFn(fn)
+}
+
+func (tc textCodec) HandleRequest(cra *CodecRequestArgs) {
+	handleValueRequest(tc, cra)
+}
+
+func (tc textCodec) decode(inArg reflect.Type, body io.ReadCloser, maxSizeBytes int64) (reflect.Value, error) {
+	var text []byte
+	if body != nil {
+		var err error
+		text, err = ioutil.ReadAll(io.LimitReader(body, maxSizeBytes))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	switch {
+	case inArg.Kind() == reflect.String:
+		return reflect.ValueOf(string(text)).Convert(inArg), nil
+	case inArg.Kind() == reflect.Slice && inArg.Elem().Kind() == reflect.Uint8:
+		return reflect.ValueOf(text).Convert(inArg), nil
+	case inArg.Implements(textUnmarshalerType) && inArg.Kind() == reflect.Ptr:
+		object := newReflectType(inArg)
+		err := object.Interface().(encoding.TextUnmarshaler).UnmarshalText(text)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		return object, nil
+	case reflect.PtrTo(inArg).Implements(textUnmarshalerType):
+		object := reflect.New(inArg)
+		err := object.Interface().(encoding.TextUnmarshaler).UnmarshalText(text)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		return object.Elem(), nil
+	}
+
+	return reflect.Value{}, ErrNotText
+}
+
+func (tc textCodec) encode(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		// nil pointers implementing fmt.Stringer or encoding.TextMarshaler could panic
+		return nil
+	}
+
+	if (rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8) || rv.Kind() == reflect.Array {
+		for i := 0; i < rv.Len(); i++ {
+			err := tc.encode(w, rv.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+
+			_, err = io.WriteString(w, "\n")
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(text)
+		return err
+	case fmt.Stringer:
+		_, err := io.WriteString(w, x.String())
+		return err
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		_, err := io.WriteString(w, rv.String())
+		return err
+	case reflect.Slice:
+		_, err := w.Write(rv.Bytes())
+		return err
+	}
+
+	_, err := fmt.Fprint(w, v)
+	return err
+}
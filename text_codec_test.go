@@ -0,0 +1,113 @@
+package autoroute
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTextHandlerStrings(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(s string) []string {
+		return strings.Split(s, " ")
+	}, WithCodec(TextCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("test string split me"))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	handler.ServeHTTP(w, req)
+
+	if w.Body.String() != "test\nstring\nsplit\nme\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+}
+
+func TestTextHandlerJSONBody(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(s string) []string {
+		return strings.Split(s, " ")
+	}, WithCodec(TextCodec), WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`"split me"`))
+	req.Header.Set("Content-Type", "application/json")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `["split","me"]`, w.Body.String())
+}
+
+func TestTextHandlerTextMarshalers(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func(ip *net.IP) (net.IP, error) {
+		return (*ip).To16(), nil
+	}, WithCodec(TextCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("127.0.0.1"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	handler.ServeHTTP(w, req)
+
+	if w.Body.String() != "127.0.0.1" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestTextHandlerNilPointer(t *testing.T) {
+	t.Parallel()
+
+	handler, err := NewHandler(func() *net.IP {
+		return nil
+	}, WithCodec(TextCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Content-Type", "text/plain")
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Fatalf("expected an empty 200, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTextHandlerNotText(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	handler, err := NewHandler(ts.DoThing, WithCodec(TextCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("yo"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	handler.ServeHTTP(w, req)
+
+	diffJSON(t, `{"error":"`+ErrNotText.Error()+`"}`, w.Body.String())
+}
@@ -0,0 +1,421 @@
+package autoroute
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// A Validator is an input type that checks itself once it's decoded and its
+// validate tags pass. Returning a ValidationError reports specific fields.
+type Validator interface {
+	Validate() error
+}
+
+// A FieldError is a single failed check, with the path of the field using its
+// JSON name, such as items[2].email
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// A ValidationError is returned when a decoded input fails its checks, and is
+// reported as a 422 by DefaultErrorHandler
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (ve *ValidationError) Error() string {
+	messages := make([]string, len(ve.Fields))
+	for i, fe := range ve.Fields {
+		messages[i] = fe.Message
+		if fe.Field != "" {
+			messages[i] = fe.Field + " " + fe.Message
+		}
+	}
+
+	return "autoroute: invalid input: " + strings.Join(messages, ", ")
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// a validateRule is a single rule of a validate tag, such as min=1
+type validateRule struct {
+	name  string
+	param string
+	// min and max, parsed
+	bound float64
+}
+
+// fieldRules are the rules of a single struct field, from its validate and default tags
+type fieldRules struct {
+	index    int
+	name     string
+	required bool
+	rules    []validateRule
+
+	hasDefault   bool
+	defaultValue reflect.Value
+}
+
+// map[reflect.Type][]fieldRules
+var structRulesCache sync.Map
+
+// structRules parses the validate and default tags of a struct type's exported fields.
+// Rules it doesn't know, such as go-playground/validator's gte or required_if, are
+// ignored rather than refused, so types already tagged for another validator still
+// register, and so is everything after dive, which applies to elements.
+func structRules(t reflect.Type) ([]fieldRules, error) {
+	cached, ok := structRulesCache.Load(t)
+	if ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var all []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		fr := fieldRules{
+			index: i,
+			name:  jsonFieldName(sf),
+		}
+
+		// failures of a bound struct are reported by header or cookie name
+		if header := sf.Tag.Get("header"); header != "" && header != "-" {
+			fr.name = header
+		} else if cookie := sf.Tag.Get("cookie"); cookie != "" && cookie != "-" {
+			fr.name = cookie
+		}
+
+		if def, ok := sf.Tag.Lookup("default"); ok {
+			fr.hasDefault = true
+			fr.defaultValue = reflect.New(sf.Type).Elem()
+			err := setFromString(fr.defaultValue, def)
+			if err != nil {
+				return nil, fmt.Errorf("autoroute: invalid default %q on %s.%s: %v", def, t.Name(), sf.Name, err)
+			}
+		}
+
+		tag := sf.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			all = append(all, fr)
+			continue
+		}
+
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+	rules:
+		for _, part := range strings.Split(tag, ",") {
+			name, param := part, ""
+			if i := strings.Index(part, "="); i >= 0 {
+				name, param = part[:i], part[i+1:]
+			}
+
+			rule := validateRule{name: name, param: param}
+			kindOK := true
+			switch name {
+			case "required":
+				fr.required = true
+				continue
+			case "dive":
+				break rules
+			case "min", "max":
+				bound, err := strconv.ParseFloat(param, 64)
+				if err != nil {
+					return nil, fmt.Errorf("autoroute: invalid validate rule %q on %s.%s", part, t.Name(), sf.Name)
+				}
+				rule.bound = bound
+				kindOK = isStringKind(ft) || isNumberKind(ft) || isLenKind(ft)
+			case "email", "uuid":
+				kindOK = isStringKind(ft)
+			case "oneof":
+				if strings.TrimSpace(param) == "" {
+					return nil, fmt.Errorf("autoroute: invalid validate rule %q on %s.%s", part, t.Name(), sf.Name)
+				}
+				kindOK = isStringKind(ft) || isNumberKind(ft)
+			default:
+				// omitempty is what fields that aren't required do anyway
+				continue
+			}
+
+			if !kindOK {
+				return nil, fmt.Errorf("autoroute: validate rule %q can't be used on %s.%s of type %s", name, t.Name(), sf.Name, sf.Type)
+			}
+
+			fr.rules = append(fr.rules, rule)
+		}
+
+		all = append(all, fr)
+	}
+
+	structRulesCache.Store(t, all)
+
+	return all, nil
+}
+
+// checkInputTags parses every validate and default tag reachable from t, so bad tags
+// are caught by NewHandler rather than on a request
+func checkInputTags(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map || t.Kind() == reflect.Chan {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	_, err := structRules(t)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+
+		err = checkInputTags(t.Field(i).Type, seen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateInput runs the validate tags and Validate methods of a decoded input,
+// returning a *ValidationError listing every failure
+func validateInput(v reflect.Value) error {
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+		// make v addressable, so Validate methods with pointer receivers are found
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+
+	vd := &inputValidator{}
+	vd.walk("", v)
+
+	if len(vd.fields) > 0 {
+		return &ValidationError{Fields: vd.fields}
+	}
+
+	return nil
+}
+
+type inputValidator struct {
+	fields []FieldError
+}
+
+func (vd *inputValidator) fail(path, message string) {
+	vd.fields = append(vd.fields, FieldError{Field: path, Message: message})
+}
+
+// walk checks every struct field reachable from v against its tags, then calls the
+// Validate method of each value whose own fields passed, innermost first
+func (vd *inputValidator) walk(path string, v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	failed := len(vd.fields)
+	switch v.Kind() {
+	case reflect.Struct:
+		rules, err := structRules(v.Type())
+		if err != nil {
+			vd.fail(path, err.Error())
+			return
+		}
+
+		for _, fr := range rules {
+			fieldPath := fr.name
+			if path != "" {
+				fieldPath = path + "." + fr.name
+			}
+
+			fieldFailed := len(vd.fields)
+			vd.check(fieldPath, v.Field(fr.index), fr)
+			if len(vd.fields) == fieldFailed {
+				vd.walk(fieldPath, v.Field(fr.index))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			vd.walk(fmt.Sprintf("%s[%d]", path, i), v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			vd.walk(fmt.Sprintf("%s[%v]", path, iter.Key().Interface()), iter.Value())
+		}
+	}
+
+	if len(vd.fields) == failed {
+		vd.callValidate(path, v)
+	}
+}
+
+// callValidate calls the Validate method of v, if it has one
+func (vd *inputValidator) callValidate(path string, v reflect.Value) {
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+
+	validator, ok := v.Interface().(Validator)
+	if !ok {
+		return
+	}
+
+	err := validator.Validate()
+	if err == nil {
+		return
+	}
+
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		vd.fail(path, err.Error())
+		return
+	}
+
+	for _, fe := range ve.Fields {
+		switch {
+		case path == "":
+		case fe.Field == "":
+			fe.Field = path
+		default:
+			fe.Field = path + "." + fe.Field
+		}
+
+		vd.fields = append(vd.fields, fe)
+	}
+}
+
+// check runs the rules of a single field. Fields that aren't required skip their
+// rules when empty, but a pointer that was set is checked whatever it points to.
+func (vd *inputValidator) check(path string, v reflect.Value, fr fieldRules) {
+	set := false
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if fr.required {
+				vd.fail(path, "is required")
+			}
+			return
+		}
+		v = v.Elem()
+		set = true
+	}
+
+	if !set && (v.IsZero() || (isLenKind(v.Type()) && v.Len() == 0)) {
+		if fr.required {
+			vd.fail(path, "is required")
+		}
+		return
+	}
+
+	for _, rule := range fr.rules {
+		message := checkRule(rule, v)
+		if message != "" {
+			vd.fail(path, message)
+			return
+		}
+	}
+}
+
+// checkRule returns why v fails rule, or nothing when it passes
+func checkRule(rule validateRule, v reflect.Value) string {
+	switch rule.name {
+	case "min", "max":
+		n, verb, unit := 0.0, "must be", ""
+		switch {
+		case isStringKind(v.Type()):
+			n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+		case isLenKind(v.Type()):
+			n, verb, unit = float64(v.Len()), "must have", " items"
+		default:
+			n = numberValue(v)
+		}
+
+		if rule.name == "min" && n < rule.bound {
+			return verb + " at least " + rule.param + unit
+		}
+
+		if rule.name == "max" && n > rule.bound {
+			return verb + " at most " + rule.param + unit
+		}
+	case "email":
+		addr, err := mail.ParseAddress(v.String())
+		if err != nil || addr.Address != v.String() {
+			return "must be a valid email address"
+		}
+	case "uuid":
+		if !uuidRegexp.MatchString(v.String()) {
+			return "must be a valid UUID"
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, option := range strings.Fields(rule.param) {
+			if s == option {
+				return ""
+			}
+		}
+
+		return "must be one of " + strings.Join(strings.Fields(rule.param), ", ")
+	}
+
+	return ""
+}
+
+func numberValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	}
+
+	return v.Float()
+}
+
+func isStringKind(t reflect.Type) bool {
+	return t.Kind() == reflect.String
+}
+
+func isNumberKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+func isLenKind(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map
+}
+
+// jsonFieldName is the name encoding/json uses for a struct field
+func jsonFieldName(sf reflect.StructField) string {
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+
+	return name
+}
@@ -0,0 +1,165 @@
+package autoroute
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type SignupItem struct {
+	Name  string `json:"name" validate:"required,max=8"`
+	Count int    `json:"count" validate:"min=1,max=10"`
+}
+
+type SignupInput struct {
+	Email   string        `json:"email" validate:"required,email"`
+	Plan    string        `json:"plan" validate:"oneof=free pro"`
+	Ref     string        `json:"ref" validate:"uuid"`
+	Tags    []string      `json:"tags" validate:"max=2"`
+	Items   []*SignupItem `json:"items" validate:"required"`
+	Comment *string       `json:"comment" validate:"min=1"`
+}
+
+func (si SignupInput) Validate() error {
+	if si.Plan == "pro" && len(si.Items) > 1 {
+		return &ValidationError{Fields: []FieldError{{Field: "items", Message: "pro plans take a single item"}}}
+	}
+
+	return nil
+}
+
+func (si *SignupItem) Validate() error {
+	if si.Name == "nope" {
+		return errors.New("is not allowed")
+	}
+
+	return nil
+}
+
+func Signup(si SignupInput) TestOutput {
+	return TestOutput{Output: si.Email}
+}
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	h, err := NewHandler(Signup, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name     string
+		body     string
+		status   int
+		expected string
+	}{
+		{
+			"valid",
+			`{"email": "a@b.co", "plan": "free", "ref": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "items": [{"name": "x", "count": 1}]}`,
+			http.StatusOK,
+			`{"output":"a@b.co"}`,
+		},
+		{
+			"tags",
+			`{"email": "nope", "plan": "gold", "ref": "123", "tags": ["a", "b", "c"], "items": [{"name": "", "count": 11}], "comment": ""}`,
+			http.StatusUnprocessableEntity,
+			`{"error":"autoroute: invalid input: email must be a valid email address, plan must be one of free, pro, ref must be a valid UUID, tags must have at most 2 items, items[0].name is required, items[0].count must be at most 10, comment must be at least 1 characters",` +
+				`"fields":[{"field":"email","message":"must be a valid email address"},{"field":"plan","message":"must be one of free, pro"},{"field":"ref","message":"must be a valid UUID"},{"field":"tags","message":"must have at most 2 items"},{"field":"items[0].name","message":"is required"},{"field":"items[0].count","message":"must be at most 10"},{"field":"comment","message":"must be at least 1 characters"}]}`,
+		},
+		{
+			"required",
+			`{}`,
+			http.StatusUnprocessableEntity,
+			`{"error":"autoroute: invalid input: email is required, items is required","fields":[{"field":"email","message":"is required"},{"field":"items","message":"is required"}]}`,
+		},
+		{
+			"validate methods",
+			`{"email": "a@b.co", "plan": "pro", "items": [{"name": "x", "count": 1}, {"name": "nope", "count": 1}]}`,
+			http.StatusUnprocessableEntity,
+			`{"error":"autoroute: invalid input: items[1] is not allowed","fields":[{"field":"items[1]","message":"is not allowed"}]}`,
+		},
+		{
+			"top level validate method",
+			`{"email": "a@b.co", "plan": "pro", "items": [{"name": "x", "count": 1}, {"name": "y", "count": 1}]}`,
+			http.StatusUnprocessableEntity,
+			`{"error":"autoroute: invalid input: items pro plans take a single item","fields":[{"field":"items","message":"pro plans take a single item"}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		req.Header.Set(MimeTypeHeader, "application/json")
+		h.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Fatalf("%s: expected status %d, got %d", c.name, c.status, w.Code)
+		}
+
+		diffJSON(t, c.expected, w.Body.String())
+	}
+}
+
+func TestValidationBadTags(t *testing.T) {
+	t.Parallel()
+
+	type BadTag struct {
+		Count int `validate:"email"`
+	}
+
+	_, err := NewHandler(func(bt *BadTag) {}, WithCodec(JSONCodec))
+	if err == nil || !strings.Contains(err.Error(), `validate rule "email" can't be used`) {
+		t.Fatalf("expected a bad tag error, got %v", err)
+	}
+
+}
+
+func TestValidationUnknownRules(t *testing.T) {
+	t.Parallel()
+
+	// tagged for go-playground/validator, whose rules after dive apply to elements
+	type PlaygroundTags struct {
+		Name  string   `json:"name" validate:"required,gte=2"`
+		Tags  []string `json:"tags" validate:"omitempty,max=3,dive,min=2"`
+		Email string   `json:"email" validate:"required_if=Name x,omitempty,email"`
+	}
+
+	h, err := NewHandler(func(pt PlaygroundTags) TestOutput {
+		return TestOutput{Output: pt.Name}
+	}, WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name     string
+		body     string
+		status   int
+		expected string
+	}{
+		{"valid", `{"name": "x", "tags": ["a"]}`, http.StatusOK, `{"output":"x"}`},
+		{
+			"known rules",
+			`{"tags": ["a", "b", "c", "d"], "email": "nope"}`,
+			http.StatusUnprocessableEntity,
+			`{"error":"autoroute: invalid input: name is required, tags must have at most 3 items, email must be a valid email address",` +
+				`"fields":[{"field":"name","message":"is required"},{"field":"tags","message":"must have at most 3 items"},{"field":"email","message":"must be a valid email address"}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		req.Header.Set(MimeTypeHeader, "application/json")
+		h.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Fatalf("%s: expected status %d, got %d", c.name, c.status, w.Code)
+		}
+
+		diffJSON(t, c.expected, w.Body.String())
+	}
+}
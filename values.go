@@ -0,0 +1,107 @@
+package autoroute
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+)
+
+// RequestMiddleware is Middleware which can replace the request the rest of the chain
+// and the function see, usually with one carrying values from SetValue. BeforeRequest
+// is called in place of Before, and returning a nil request keeps the current one.
+type RequestMiddleware interface {
+	Middleware
+
+	BeforeRequest(r *http.Request, h *Handler) (*http.Request, error)
+}
+
+// ValueMiddleware is RequestMiddleware which declares the types of the values it sets
+// with SetValue, such as (*Claims)(nil), so functions behind it can take them as args.
+// A function arg of an interface type takes any declared value implementing it.
+// An arg whose value wasn't set for a request gets its zero value.
+type ValueMiddleware interface {
+	RequestMiddleware
+
+	Values() []interface{}
+}
+
+// the context key of a value set by SetValue, there's one per type
+type valueKey struct {
+	t reflect.Type
+}
+
+// SetValue returns a shallow copy of r whose context carries v, keyed by its type.
+// Setting another value of the same type replaces it. A v implementing Principal also
+// becomes the request's principal.
+func SetValue(r *http.Request, v interface{}) *http.Request {
+	ctx := context.WithValue(r.Context(), valueKey{t: reflect.TypeOf(v)}, v)
+	if p, ok := v.(Principal); ok {
+		ctx = context.WithValue(ctx, principalKey{}, p)
+	}
+
+	return r.WithContext(ctx)
+}
+
+// ContextValue sets target, a pointer, to the value of its element type set by
+// SetValue, reporting whether there was one
+func ContextValue(ctx context.Context, target interface{}) bool {
+	tv := reflect.ValueOf(target)
+	if tv.Kind() != reflect.Ptr || tv.IsNil() {
+		panic("autoroute: ContextValue target must be a non-nil pointer")
+	}
+
+	v := ctx.Value(valueKey{t: tv.Type().Elem()})
+	if v == nil {
+		return false
+	}
+
+	tv.Elem().Set(reflect.ValueOf(v))
+	return true
+}
+
+// declaredValues collects the types of values declared by any ValueMiddleware
+func declaredValues(middlewares []Middleware) []reflect.Type {
+	var types []reflect.Type
+	for _, mw := range middlewares {
+		vm, ok := mw.(ValueMiddleware)
+		if !ok {
+			continue
+		}
+
+		for _, v := range vm.Values() {
+			types = append(types, reflect.TypeOf(v))
+		}
+	}
+
+	return types
+}
+
+// valueTypes returns the declared types a function arg of type t can take, or none
+// when it doesn't come from the context
+func valueTypes(t reflect.Type, declared []reflect.Type) []reflect.Type {
+	var matches []reflect.Type
+	for _, dt := range declared {
+		if dt == t {
+			return []reflect.Type{dt}
+		}
+
+		if t.Kind() == reflect.Interface && dt.Implements(t) {
+			matches = append(matches, dt)
+		}
+	}
+
+	return matches
+}
+
+// contextValue resolves an arg set by SetValue, trying each of its types in turn
+func contextValue(ctx context.Context, spec argSpec) reflect.Value {
+	for _, t := range spec.values {
+		if v := ctx.Value(valueKey{t: t}); v != nil {
+			out := reflect.New(spec.t).Elem()
+			out.Set(reflect.ValueOf(v))
+			return out
+		}
+	}
+
+	return reflect.Zero(spec.t)
+}
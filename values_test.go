@@ -0,0 +1,154 @@
+package autoroute
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testPrincipal struct {
+	Subject string
+}
+
+type testNamer interface {
+	Name() string
+}
+
+func (tp *testPrincipal) Name() string {
+	return tp.Subject
+}
+
+// principalMiddleware trusts an x-user header, and lets anonymous requests through
+type principalMiddleware struct {
+	afterSubject string
+}
+
+func (pm *principalMiddleware) Before(r *http.Request, h *Handler) error {
+	return errors.New("BeforeRequest should be called instead")
+}
+
+func (pm *principalMiddleware) BeforeRequest(r *http.Request, h *Handler) (*http.Request, error) {
+	user := r.Header.Get("x-user")
+	if user == "" {
+		return nil, nil
+	}
+
+	if user == "banned" {
+		return nil, MiddlewareError{StatusCode: http.StatusForbidden, Err: errors.New("banned")}
+	}
+
+	return SetValue(r, &testPrincipal{Subject: user}), nil
+}
+
+func (pm *principalMiddleware) Values() []interface{} {
+	return []interface{}{(*testPrincipal)(nil)}
+}
+
+func (pm *principalMiddleware) After(w ResponseWriter, r *http.Request, h *Handler) {
+	var p *testPrincipal
+	if ContextValue(r.Context(), &p) {
+		pm.afterSubject = p.Subject
+	}
+}
+
+func TestMiddlewareValues(t *testing.T) {
+	t.Parallel()
+
+	pm := &principalMiddleware{}
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(pm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/whoami", func(ctx context.Context, p *testPrincipal, ti TestInput) TestOutput {
+		var fromCtx *testPrincipal
+		ContextValue(ctx, &fromCtx)
+
+		if p == nil {
+			return TestOutput{Output: "anonymous " + ti.Input}
+		}
+
+		return TestOutput{Output: p.Subject + " " + fromCtx.Subject + " " + ti.Input}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// interface args take a declared value implementing them
+	err = router.Register(http.MethodGet, "/name", func(n testNamer) TestOutput {
+		return TestOutput{Output: n.Name()}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		method, path, user string
+		status             int
+		body               string
+	}{
+		{http.MethodPost, "/whoami", "ian", http.StatusOK, `{"output":"ian ian hi"}`},
+		{http.MethodPost, "/whoami", "", http.StatusOK, `{"output":"anonymous hi"}`},
+		{http.MethodPost, "/whoami", "banned", http.StatusForbidden, ""},
+		{http.MethodGet, "/name", "ian", http.StatusOK, `{"output":"ian"}`},
+	}
+
+	for _, tc := range tcs {
+		pm.afterSubject = ""
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"input": "hi"}`))
+		req.Header.Set(MimeTypeHeader, "application/json")
+		if tc.user != "" {
+			req.Header.Set("x-user", tc.user)
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s as %q: expected %d, got %d %s", tc.path, tc.user, tc.status, w.Code, w.Body.String())
+		}
+
+		if tc.status != http.StatusOK {
+			continue
+		}
+
+		diffJSON(t, tc.body, w.Body.String())
+
+		// After middleware sees the request the function saw
+		if tc.user != "" && pm.afterSubject != tc.user {
+			t.Fatalf("expected After to see %q, got %q", tc.user, pm.afterSubject)
+		}
+	}
+}
+
+func TestMiddlewareValuesJSONRPC(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/whoami", func(p *testPrincipal) TestOutput {
+		return TestOutput{Output: p.Subject}
+	}, WithName("whoami"), WithMiddleware(&principalMiddleware{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.JSONRPC("/rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"whoami","id":1}`))
+	req.Header.Set(MimeTypeHeader, "application/json")
+	req.Header.Set("x-user", "ian")
+	router.ServeHTTP(w, req)
+
+	diffJSON(t, `{"jsonrpc":"2.0","result":{"output":"ian"},"id":1}`, w.Body.String())
+}
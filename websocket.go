@@ -0,0 +1,274 @@
+package autoroute
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonaut/autoroute/internal/websocket"
+)
+
+// webSocketEnvelopeBytes is the room left in a message for its id and method on top
+// of the largest params a handler accepts
+const webSocketEnvelopeBytes = 4096
+
+type WebSocketOption func(wh *webSocketHandler)
+
+// WithWebSocketPingInterval pings each client every d, 30 seconds by default, and drops
+// connections that stay silent for two intervals. Zero disables pings.
+func WithWebSocketPingInterval(d time.Duration) WebSocketOption {
+	return func(wh *webSocketHandler) {
+		wh.pingInterval = d
+	}
+}
+
+// WithWebSocketMaxInFlight limits how many calls a single connection can have running
+// at once, 8 by default. Further messages aren't read until a call finishes.
+func WithWebSocketMaxInFlight(n int) WebSocketOption {
+	return func(wh *webSocketHandler) {
+		if n < 1 {
+			n = 1
+		}
+		wh.maxInFlight = n
+	}
+}
+
+// WithWebSocketOrigins allows browsers on other origins to connect, patterned as with
+// WithCORSOrigins. Only pages served from the same host can by default, as a socket
+// carries the user's cookies and credentials to every registered handler.
+func WithWebSocketOrigins(origins ...string) WebSocketOption {
+	return func(wh *webSocketHandler) {
+		wh.origins = append(wh.origins, origins...)
+	}
+}
+
+type webSocketRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type webSocketResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result,omitempty"`
+	Error  *JSONRPCError   `json:"error,omitempty"`
+}
+
+// WebSocket serves every handler registered on the router at path over a WebSocket.
+// Clients send text messages of `{"id": ..., "method": ..., "params": {...}}` and get a
+// `{"id": ..., "result": ...}` or `{"id": ..., "error": {"code": ..., "message": ...}}`
+// message back for each, in whatever order the calls finish. Methods are named and
+// errors coded as with JSONRPC. Browsers on other origins are refused with a 403 unless
+// allowed by WithWebSocketOrigins.
+// The router's default middleware runs once against the upgrade request, and any
+// middleware added to a single handler runs against it before each call. A message can
+// be as large as the largest WithMaxSizeBytes of any handler, and each call's params
+// are checked against its own handler's limit.
+func (ro *Router) WebSocket(path string, opts ...WebSocketOption) error {
+	wh := &webSocketHandler{
+		router:       ro,
+		pingInterval: 30 * time.Second,
+		maxInFlight:  8,
+	}
+
+	for _, opt := range opts {
+		opt(wh)
+	}
+
+	return ro.Mount(path, wh)
+}
+
+type webSocketHandler struct {
+	router *Router
+
+	pingInterval time.Duration
+	maxInFlight  int
+	origins      []string
+}
+
+// upgradeHandler is a stand-in Handler carrying the router's defaults, so its
+// middleware can run against the upgrade request
+func (wh *webSocketHandler) upgradeHandler() (*Handler, error) {
+	opts := []HandlerOption{WithErrorHandler(wh.router.defaultErrorHandler)}
+	opts = append(opts, wh.router.defaultHandlerOptions...)
+	opts = append(opts, WithName("websocket"))
+
+	return NewHandler(func() {}, opts...)
+}
+
+// maxMessageSize is the largest maxSizeBytes of any registered handler, plus room for
+// the envelope
+func (wh *webSocketHandler) maxMessageSize(uh *Handler) int64 {
+	max := uh.maxSizeBytes
+	for _, h := range wh.router.handlers {
+		if h.maxSizeBytes > max {
+			max = h.maxSizeBytes
+		}
+	}
+
+	return max + webSocketEnvelopeBytes
+}
+
+// originAllowed checks the Origin browsers send with the upgrade request, which is
+// the only thing stopping other sites from opening a socket as the user
+func (wh *webSocketHandler) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, pattern := range wh.origins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) || subdomainMatch(strings.ToLower(pattern), strings.ToLower(origin)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (wh *webSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !wh.originAllowed(r) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	uh, err := wh.upgradeHandler()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		wh.router.defaultErrorHandler(w, err)
+		return
+	}
+
+	// calls see the request router middleware returned
+	r, err = uh.before(r, uh.middlewares)
+	if err != nil {
+		uh.writeMiddlewareError(w, err)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		// Upgrade already responded
+		return
+	}
+
+	conn.MaxMessageSize = wh.maxMessageSize(uh)
+	if wh.pingInterval > 0 {
+		conn.ReadTimeout = 2 * wh.pingInterval
+	}
+
+	wh.serve(r, conn)
+}
+
+// serve reads messages off conn until it's closed, running each call in its own goroutine
+func (wh *webSocketHandler) serve(r *http.Request, conn *websocket.Conn) {
+	ctx, cancel := context.WithCancel(r.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		conn.Close()
+	}()
+
+	if wh.pingInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wh.ping(ctx, conn)
+		}()
+	}
+
+	sem := make(chan struct{}, wh.maxInFlight)
+	for {
+		opcode, data, err := conn.ReadMessage()
+		if err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok {
+				conn.WriteClose(websocket.CloseGoingAway, "")
+			}
+			return
+		}
+
+		if opcode != websocket.OpText {
+			conn.WriteClose(websocket.CloseUnsupportedData, "only text messages are supported")
+			return
+		}
+
+		var req webSocketRequest
+		err = json.Unmarshal(data, &req)
+		if err != nil {
+			wh.reply(conn, &webSocketResponse{
+				ID:    jsonNull,
+				Error: &JSONRPCError{Code: JSONRPCParseError, Message: err.Error()},
+			})
+			continue
+		}
+
+		if len(req.ID) == 0 {
+			req.ID = jsonNull
+		}
+
+		if req.Method == "" {
+			wh.reply(conn, &webSocketResponse{
+				ID:    req.ID,
+				Error: &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "method must be a string"},
+			})
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			result, je := wh.router.invokeRPC(r.WithContext(ctx), req.Method, req.Params, true)
+			wh.reply(conn, &webSocketResponse{
+				ID:     req.ID,
+				Result: result,
+				Error:  je,
+			})
+		}()
+	}
+}
+
+func (wh *webSocketHandler) reply(conn *websocket.Conn, resp *webSocketResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		msg, _ = json.Marshal(&webSocketResponse{
+			ID:    resp.ID,
+			Error: &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()},
+		})
+	}
+
+	conn.WriteMessage(websocket.OpText, msg)
+}
+
+// ping keeps the connection alive, while the client's pongs keep pushing back its read timeout
+func (wh *webSocketHandler) ping(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(wh.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := conn.WritePing(nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package autoroute
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/autonaut/autoroute/internal/websocket"
+)
+
+func dialWebSocket(t *testing.T, srv *httptest.Server, header http.Header) *websocket.Conn {
+	conn, _, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func webSocketCall(t *testing.T, conn *websocket.Conn, msg string) string {
+	err := conn.WriteMessage(websocket.OpText, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(reply)
+}
+
+func TestWebSocket(t *testing.T) {
+	t.Parallel()
+	ts := &TestServer{}
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithMaxSizeBytes(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/thing", ts.DoThing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/error", ts.DoThingErrorReturn, WithName("fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/restricted", ts.DoThingValueArgs, WithMiddleware(NewBasicAuthMiddleware("user", "user")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.WebSocket("/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("user:user")))
+	conn := dialWebSocket(t, srv, header)
+	defer conn.Close()
+
+	var cases = []struct {
+		name     string
+		msg      string
+		expected string
+	}{
+		{
+			"call",
+			`{"id": 1, "method": "DoThing", "params": {"input": "yo"}}`,
+			`{"id":1,"result":{"output":"hi"}}`,
+		},
+		{
+			"handler error",
+			`{"id": "a", "method": "fail"}`,
+			`{"id":"a","error":{"code":-32000,"message":"sup"}}`,
+		},
+		{
+			"handler middleware",
+			`{"id": 2, "method": "DoThingValueArgs", "params": {"input": "yo"}}`,
+			`{"id":2,"result":{"output":"hi"}}`,
+		},
+		{
+			"method not found",
+			`{"id": 3, "method": "missing"}`,
+			`{"id":3,"error":{"code":-32601,"message":"method not found"}}`,
+		},
+		{
+			"params too large",
+			`{"id": 4, "method": "DoThing", "params": {"input": "` + strings.Repeat("a", 64) + `"}}`,
+			`{"id":4,"error":{"code":-32602,"message":"params too large"}}`,
+		},
+		{
+			"parse error",
+			`{"id": 5`,
+			`{"id":null,"error":{"code":-32700,"message":"unexpected end of JSON input"}}`,
+		},
+	}
+
+	for _, c := range cases {
+		diffJSON(t, c.expected, webSocketCall(t, conn, c.msg))
+	}
+
+	// messages are limited by the largest maxSizeBytes, plus room for the envelope
+	err = conn.WriteMessage(websocket.OpText, []byte(`{"id": 6, "method": "DoThing", "params": "`+strings.Repeat("a", 64+webSocketEnvelopeBytes)+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = conn.ReadMessage()
+	ce, ok := err.(*websocket.CloseError)
+	if !ok || ce.Code != websocket.CloseMessageTooBig {
+		t.Fatalf("expected a message too big close, got %v", err)
+	}
+}
+
+func TestWebSocketConcurrentCalls(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	err = router.Register(http.MethodPost, "/wait", func(ctx context.Context, ti *TestInput) (*TestOutput, error) {
+		select {
+		case <-release:
+			return &TestOutput{Output: "waited"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}, WithName("wait"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/release", func() TestOutput {
+		close(release)
+		return TestOutput{Output: "released"}
+	}, WithName("release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.WebSocket("/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	conn := dialWebSocket(t, srv, nil)
+	defer conn.Close()
+
+	err = conn.WriteMessage(websocket.OpText, []byte(`{"id": 1, "method": "wait"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wait is still running, so release answers first
+	diffJSON(t, `{"id":2,"result":{"output":"released"}}`, webSocketCall(t, conn, `{"id": 2, "method": "release"}`))
+
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffJSON(t, `{"id":1,"result":{"output":"waited"}}`, string(reply))
+}
+
+func TestWebSocketUpgradeMiddleware(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec), WithMiddleware(NewBasicAuthMiddleware("user", "user")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.WebSocket("/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	_, resp, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != websocket.ErrBadHandshake {
+		t.Fatalf("expected a bad handshake, got %v", err)
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(WithCodec(JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(http.MethodPost, "/thing", (&TestServer{}).DoThing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.WebSocket("/ws", WithWebSocketOrigins("https://*.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{srv.URL, true},
+		{"https://app.example.com", true},
+		{"https://evil.example.net", false},
+		{"https://example.com", false},
+	} {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+
+		conn, resp, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+		if !tc.allowed {
+			if err != websocket.ErrBadHandshake || resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("%q: expected a 403, got %v", tc.origin, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%q: %v", tc.origin, err)
+		}
+
+		reply := webSocketCall(t, conn, `{"id": 1, "method": "DoThing", "params": {"input": "yo"}}`)
+		conn.Close()
+		diffJSON(t, `{"id":1,"result":{"output":"hi"}}`, reply)
+	}
+}